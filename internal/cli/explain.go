@@ -0,0 +1,162 @@
+package cli
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jpvargasdev/magos-dominus/internal/daemon"
+	"github.com/jpvargasdev/magos-dominus/internal/state"
+	"github.com/spf13/cobra"
+)
+
+var statePath string
+
+func init() {
+	explainCmd.Flags().StringVar(&statePath, "state", daemon.StatePath, "Path to the daemon state file")
+	rootCmd.AddCommand(explainCmd)
+}
+
+var explainCmd = &cobra.Command{
+	Use:   "explain [image]",
+	Short: "Show why targets were or were not updated on their last check",
+	Long: "explain prints the last policy decision recorded for each target in the state file: " +
+		"the tags considered, the ones filtered out and why, the chosen tag and digest, and the outcome. " +
+		"An image argument (e.g. ghcr.io/owner/app) limits the output to matching targets.",
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		st := state.New(statePath)
+		if err := st.Load(); err != nil {
+			return fmt.Errorf("state load: %w", err)
+		}
+		filter := ""
+		if len(args) == 1 {
+			filter = strings.ToLower(args[0])
+		}
+		n := 0
+		for _, key := range st.Keys() {
+			if filter != "" && !strings.Contains(key, filter) {
+				continue
+			}
+			e, _ := st.Get(key)
+			c, _ := st.LastCheck(key)
+			writeExplanation(os.Stdout, key, e, c)
+			n++
+		}
+		n += writePending(os.Stdout, st.PendingUpdates(), filter)
+		if n == 0 {
+			return fmt.Errorf("no targets in %s match %q", statePath, filter)
+		}
+		return nil
+	},
+}
+
+// writeExplanation prints one state entry and its last decision.
+func writeExplanation(w io.Writer, key string, e state.Entry, c state.Check) {
+	fmt.Fprintln(w, key)
+	d := c.Decision
+	if d == nil {
+		fmt.Fprintf(w, "  no decision recorded (last checked %s)\n", formatTime(e.LastChecked))
+		writeVerifications(w, e, c)
+		fmt.Fprintln(w)
+		return
+	}
+	fmt.Fprintf(w, "  outcome:    %s at %s\n", d.Outcome, formatTime(d.At))
+	if d.Detail != "" {
+		fmt.Fprintf(w, "  detail:     %s\n", d.Detail)
+	}
+	if p := d.Policy; p != nil {
+		fmt.Fprintf(w, "  policy:     %s (current %s)\n", p.Policy, p.Current)
+		if len(p.Candidates) > 0 {
+			fmt.Fprintf(w, "  candidates: %s\n", strings.Join(p.Candidates, ", "))
+		}
+		if p.Chosen != "" {
+			fmt.Fprintf(w, "  chosen:     %s\n", p.Chosen)
+		}
+		if len(p.Rejected) > 0 {
+			fmt.Fprintln(w, "  rejected:")
+			for _, r := range p.Rejected {
+				fmt.Fprintf(w, "    %-24s %s\n", r.Tag, r.Reason)
+			}
+		}
+		if p.Omitted > 0 {
+			fmt.Fprintf(w, "  (%d more tags not listed)\n", p.Omitted)
+		}
+	} else {
+		fmt.Fprintf(w, "  policy:     %s\n", e.Policy)
+	}
+	if d.Ref != "" || d.Digest != "" {
+		fmt.Fprintf(w, "  resolved:   %s -> %s\n", d.Ref, d.Digest)
+	}
+	writeVerifications(w, e, c)
+	fmt.Fprintln(w)
+}
+
+// writeVerifications prints the signature check that admitted the current
+// digest and, when it is a different one, the last check's.
+func writeVerifications(w io.Writer, e state.Entry, c state.Check) {
+	if v := e.Verification; v != nil {
+		fmt.Fprintf(w, "  signature:  %s\n", describeVerification(*v))
+	}
+	if v := c.Verification; v != nil && (e.Verification == nil || *v != *e.Verification) {
+		fmt.Fprintf(w, "  candidate:  %s\n", describeVerification(*v))
+	}
+}
+
+func describeVerification(v state.Verification) string {
+	s := "verified"
+	if !v.Verified {
+		s = "not verified"
+	}
+	if v.Key != "" {
+		s += " with " + v.Key
+	}
+	if v.Detail != "" {
+		s += ": " + v.Detail
+	}
+	return fmt.Sprintf("%s (%s, %s)", s, v.Digest, formatTime(v.At))
+}
+
+// writePending prints the updates waiting for a maintenance window or a
+// retry and returns how many matched filter.
+func writePending(w io.Writer, pending map[string]state.Pending, filter string) int {
+	var keys []string
+	for k, p := range pending {
+		if filter == "" || strings.Contains(strings.ToLower(p.Repo), filter) || strings.Contains(filter, strings.ToLower(p.Repo)) {
+			keys = append(keys, k)
+		}
+	}
+	if len(keys) == 0 {
+		return 0
+	}
+	sort.Strings(keys)
+	fmt.Fprintln(w, "queued updates")
+	for _, k := range keys {
+		p := pending[k]
+		file := p.File
+		if p.Line > 0 {
+			file = fmt.Sprintf("%s:%d", p.File, p.Line)
+		}
+		fmt.Fprintf(w, "  %s:%s (%s) in %s\n", p.Repo, p.Ref, p.Digest, file)
+		switch {
+		case p.Attempts > 0:
+			fmt.Fprintf(w, "    %d failed attempt(s), last: %s; retries from %s\n", p.Attempts, p.Reason, formatTime(p.NotBefore))
+		case p.Reason != "":
+			fmt.Fprintf(w, "    %s, applies from %s\n", p.Reason, formatTime(p.NotBefore))
+		default:
+			fmt.Fprintln(w, "    being applied")
+		}
+	}
+	fmt.Fprintln(w)
+	return len(keys)
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "never"
+	}
+	return t.Local().Format(time.RFC3339)
+}
@@ -4,6 +4,7 @@ import (
 	"log"
 	"os"
 	"strconv"
+	"time"
 
 	"github.com/joho/godotenv"
 )
@@ -16,6 +17,9 @@ type Config struct {
   AppId          int64
   InstallationId int64 
   PrivateKeyPath string
+  PollInterval   time.Duration
+  PollWorkers    int
+  Platform       string
 }
 
 func GetGitPreferences() *Config {
@@ -30,6 +34,39 @@ func GetGitPreferences() *Config {
   }
 }
 
+// GetWatcherPreferences reads MD_POLL_INTERVAL (e.g. "5m"), the default poll
+// interval for targets whose annotation does not set one, and MD_POLL_WORKERS,
+// how many targets are checked concurrently, and MD_PLATFORM ("host" or
+// "linux/arm64"), the default platform whose image digest is tracked.
+func GetWatcherPreferences() *Config {
+  err := godotenv.Load()
+  if err != nil {
+    log.Fatal("Error loading .env file")
+  }
+
+  var interval time.Duration
+  if raw := os.Getenv("MD_POLL_INTERVAL"); raw != "" {
+    interval, err = time.ParseDuration(raw)
+    if err != nil {
+      log.Fatal("Error parsing MD_POLL_INTERVAL")
+    }
+  }
+
+  workers := 0
+  if raw := os.Getenv("MD_POLL_WORKERS"); raw != "" {
+    workers, err = strconv.Atoi(raw)
+    if err != nil {
+      log.Fatal("Error parsing MD_POLL_WORKERS")
+    }
+  }
+
+  return &Config{
+    PollInterval: interval,
+    PollWorkers:  workers,
+    Platform:     os.Getenv("MD_PLATFORM"),
+  }
+}
+
 func GetGithubConfig() *Config {
   err := godotenv.Load()
   if err != nil {
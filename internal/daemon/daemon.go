@@ -2,19 +2,39 @@ package daemon
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"os"
+	"sort"
+	"strings"
+	"time"
 
 	"github.com/jpvargasdev/magos-dominus/internal/config"
 	"github.com/jpvargasdev/magos-dominus/internal/events"
+	"github.com/jpvargasdev/magos-dominus/internal/maintenance"
 	"github.com/jpvargasdev/magos-dominus/internal/reconciler"
+	"github.com/jpvargasdev/magos-dominus/internal/scan"
 	"github.com/jpvargasdev/magos-dominus/internal/state"
 	"github.com/jpvargasdev/magos-dominus/internal/watcher"
 )
 
+// StatePath is where the daemon keeps its state file, relative to the
+// working directory.
+const StatePath = "tmp/magos/state.json"
+
 type Daemon struct {
 	events events.ChanEmitter
+
+	// set up by Start for consume
+	st       *state.File
+	regs     *watcher.Registries
+	cosign   *watcher.CosignKeys
+	fulcio   *watcher.FulcioRoots
+	rekor    *watcher.RekorKeys
+	scanner  *scan.Scanner
+	rules    map[string]TargetRule
+	schedule *maintenance.Schedule // global, for targets without their own
 }
 
 func New(buffer int) *Daemon {
@@ -27,56 +47,237 @@ func (d *Daemon) EventsEmitter() events.Emitter {
 	return d.events
 }
 
+// Retries of an update whose checks failed or blocked it start after
+// retryDelay and double with every attempt, up to maxRetryDelay.
+const (
+	retryDelay    = 15 * time.Minute
+	maxRetryDelay = 24 * time.Hour
+)
+
+// provenanceTimeout bounds the attestation lookup for one update.
+const provenanceTimeout = 2 * time.Minute
+
+// consume queues update events for applyLoop, so slow checks never hold up
+// the events channel and the state file keeps updates across restarts.
 func (d *Daemon) consume(ctx context.Context, rm *RepoManager) {
+	wake := make(chan struct{}, 1)
+	wake <- struct{}{} // updates queued before a restart
+	go d.applyLoop(ctx, rm, wake)
 	for {
 		select {
 		case <-ctx.Done():
 			return
 		case ev := <-d.events:
-			cfg := config.GetGitPreferences()
+			// a newer update supersedes one still queued
+			d.queue(targetKey(ev.File, ev.Line), ev, time.Now())
+			select {
+			case wake <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
 
-			log.Printf("[event] repo=%s ref=%s digest=%s", ev.Repo, ev.Ref, ev.Digest)
+// applyLoop applies queued updates one at a time, when consume queues one
+// and every minute for windows that open and retries that come due.
+func (d *Daemon) applyLoop(ctx context.Context, rm *RepoManager, wake <-chan struct{}) {
+	tick := time.NewTicker(time.Minute)
+	defer tick.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-wake:
+		case <-tick.C:
+		}
+		d.applyDue(ctx, rm, time.Now())
+	}
+}
 
-			// 1) sync
-			if err := rm.Sync(); err != nil {
-				log.Printf("[error] repo sync: %v", err)
-				continue
-			}
+// applyDue applies the queued updates that are due and inside their
+// target's window. An update that fails or is blocked stays queued and is
+// retried with backoff, since the watcher will not offer its digest again.
+func (d *Daemon) applyDue(ctx context.Context, rm *RepoManager, now time.Time) {
+	pending := d.st.PendingUpdates()
+	keys := make([]string, 0, len(pending))
+	for key := range pending {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
 
-			// 2) update image in the specific file
-			changed, err := rm.UpdateImage(ev.File, ev.Ref, ev.Digest, ev.Policy)
-			if err != nil {
-				log.Printf("[error] update image: %v", err)
-				continue
-			}
-			if !changed {
-				log.Printf("[event] no changes")
-				continue
-			}
+	for _, key := range keys {
+		p := pending[key]
+		if now.Before(p.NotBefore) {
+			continue
+		}
+		if open, _ := d.scheduleFor(key).Open(now); !open {
+			continue
+		}
+		if p.Reason != "" {
+			log.Printf("[schedule] applying queued %s:%s (%s)", p.Repo, p.Ref, p.Reason)
+		}
+		err := d.apply(ctx, rm, events.Event{
+			File: p.File, Line: p.Line, Repo: p.Repo, Ref: p.Ref, Digest: p.Digest, Policy: p.Policy, Discovered: p.Discovered,
+		})
+		if ctx.Err() != nil {
+			return // shutting down; the update stays queued
+		}
+		if err == nil {
+			d.settle(key, p.Digest, nil)
+			continue
+		}
+		p.Attempts++
+		p.Reason = err.Error()
+		p.NotBefore = time.Now().Add(retryAfter(p.Attempts)).UTC()
+		log.Printf("[retry] %s:%s (%s) held back after %d attempt(s), next try %s",
+			p.Repo, p.Ref, p.Digest, p.Attempts, p.NotBefore.Local().Format(time.RFC3339))
+		d.settle(key, p.Digest, &p)
+	}
+}
 
-			log.Printf("[event] updated %s", ev.File)
+// retryAfter is the wait before the next attempt at an update that has
+// failed attempts times.
+func retryAfter(attempts int) time.Duration {
+	delay := retryDelay
+	for i := 1; i < attempts && delay < maxRetryDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, maxRetryDelay)
+}
 
-			// 3) commit & push (or PR) — one file per event
-			if err := rm.CommitAndPush(ev.File, cfg.PreferPR); err != nil {
-				log.Printf("[error] commit and push: %v", err)
-				continue
-			}
+// scheduleFor returns the maintenance schedule of the target behind key.
+func (d *Daemon) scheduleFor(key string) *maintenance.Schedule {
+	if r, ok := d.rules[key]; ok && r.Schedule != nil {
+		return r.Schedule
+	}
+	return d.schedule
+}
 
-			// 4) reconcile hook (placeholder)
-			log.Printf("[event] running reconcile.sh")
-			if err := reconciler.RunReconcile(ctx, os.Getenv("MD_RECONCILE_SCRIPT"), rm.Path, ev.File, ev.Policy); err != nil {
-				log.Printf("[error] reconcile: %v", err)
-			}
+// queue stores ev for applyDue, replacing any update queued under key. One
+// found outside its target's window waits for the window to open.
+func (d *Daemon) queue(key string, ev events.Event, now time.Time) {
+	p := state.Pending{
+		File: ev.File, Line: ev.Line, Repo: ev.Repo, Ref: ev.Ref, Digest: ev.Digest, Policy: ev.Policy,
+		Discovered: ev.Discovered,
+	}
+	if open, why := d.scheduleFor(key).Open(now); !open {
+		p.Reason = why
+		next := "no window within a year"
+		if t, ok := d.scheduleFor(key).NextOpen(now); ok {
+			p.NotBefore = t.UTC()
+			next = t.Format(time.RFC3339)
 		}
+		log.Printf("[schedule] queued %s:%s (%s): %s, next window %s", ev.Repo, ev.Ref, ev.Digest, why, next)
+	}
+	d.st.QueuePending(key, p)
+	if err := d.st.Save(); err != nil {
+		log.Printf("[error] state save: %v", err)
+	}
+}
+
+func (d *Daemon) settle(key, digest string, next *state.Pending) {
+	d.st.SettlePending(key, digest, next)
+	if err := d.st.Save(); err != nil {
+		log.Printf("[error] state save: %v", err)
 	}
 }
 
+// apply commits one update. It returns an error, for applyDue to retry,
+// when a step fails, when the digest lacks the provenance its annotation
+// requires, or when, with a scanner configured, it brings in new findings at
+// or above the threshold.
+func (d *Daemon) apply(ctx context.Context, rm *RepoManager, ev events.Event) error {
+	cfg := config.GetGitPreferences()
+
+	log.Printf("[event] repo=%s ref=%s digest=%s", ev.Repo, ev.Ref, ev.Digest)
+
+	// 1) sync
+	if err := rm.Sync(); err != nil {
+		log.Printf("[error] repo sync: %v", err)
+		return fmt.Errorf("repo sync: %w", err)
+	}
+
+	var notes []string
+	deployed, err := rm.CurrentImage(ev.File, ev.Repo, ev.Line)
+	if err != nil {
+		log.Printf("[error] current image: %v", err)
+		return err
+	}
+	candidate := stripRefOrDigest(deployed) + "@" + ev.Digest
+
+	// 2) provenance admission
+	if rule := d.rules[targetKey(ev.File, ev.Line)].Provenance; !rule.IsZero() {
+		host, _, _, _ := splitImageRef(deployed)
+		trust := watcher.ProvenanceTrust{Key: d.cosign.For(host + "/" + ev.Repo), Fulcio: d.fulcio, Rekor: d.rekor}
+		reg, err := d.regs.For(host)
+		if err == nil {
+			pctx, cancel := context.WithTimeout(ctx, provenanceTimeout)
+			err = watcher.VerifyProvenance(pctx, reg, ev.Repo, ev.Digest, rule, trust)
+			cancel()
+		}
+		if err != nil {
+			log.Printf("[provenance] BLOCKED %s: %v", candidate, err)
+			return fmt.Errorf("provenance: %w", err)
+		}
+		log.Printf("[provenance] %s: admitted", candidate)
+		notes = append(notes, fmt.Sprintf("provenance verified: builder %q, source %q", rule.Builder, rule.Source))
+	}
+
+	// 3) vulnerability gate: candidate digest vs. the deployed image
+	if d.scanner != nil {
+		res, err := d.scanner.Gate(ctx, candidate, deployed)
+		if err != nil {
+			log.Printf("[scan] BLOCKED %s: %v", candidate, err)
+			return fmt.Errorf("scan: %w", err)
+		}
+		if !res.Allowed() {
+			log.Printf("[scan] BLOCKED %s:\n%s", candidate, res.Message())
+			return errors.New(res.Summary())
+		}
+		log.Printf("[scan] %s: %s", candidate, res.Summary())
+		notes = append(notes, res.Message())
+	}
+
+	// 4) update image in the specific file
+	orig, err := os.ReadFile(ev.File)
+	if err != nil {
+		return err
+	}
+	changed, err := rm.UpdateImage(ev.File, ev.Repo, ev.Line, ev.Ref, ev.Digest, ev.Policy)
+	if err != nil {
+		log.Printf("[error] update image: %v", err)
+		return fmt.Errorf("update image: %w", err)
+	}
+	if !changed {
+		log.Printf("[event] no changes")
+		return nil
+	}
+
+	log.Printf("[event] updated %s", ev.File)
+
+	// 5) commit & push (or PR) — one file per event
+	if err := rm.CommitAndPush(ev.File, strings.Join(notes, "\n\n"), cfg.PreferPR); err != nil {
+		log.Printf("[error] commit and push: %v", err)
+		// undo the edit so the retry finds something to commit
+		if werr := os.WriteFile(ev.File, orig, 0o644); werr != nil {
+			log.Printf("[error] restore %s: %v", ev.File, werr)
+		}
+		return fmt.Errorf("commit and push: %w", err)
+	}
+
+	// 6) reconcile hook (placeholder); the commit stands either way
+	log.Printf("[event] running reconcile.sh")
+	if err := reconciler.RunReconcile(ctx, os.Getenv("MD_RECONCILE_SCRIPT"), rm.Path, ev.File, ev.Policy); err != nil {
+		log.Printf("[error] reconcile: %v", err)
+	}
+	return nil
+}
+
 func (d *Daemon) Start(ctx context.Context) error {
 	log.Printf("[daemon] starting...")
 
 	// 0. Init Magos state
-	path := "tmp/magos/state.json"
-	st := state.New(path)
+	st := state.New(StatePath)
 	if err := st.Load(); err != nil {
 		return fmt.Errorf("state load: %w", err)
 	}
@@ -101,12 +302,53 @@ func (d *Daemon) Start(ctx context.Context) error {
 		log.Printf("[warm] failed: %v", err)
 	}
 
-	// 4. Initial run
-	paths := rm.BuildReconcilePaths(annotations)
-	reconciler.RunAll(ctx, os.Getenv("MD_RECONCILE_SCRIPT"), rm.Path, paths)
+	// 4. Update gates and schedules
+	global := maintenance.Load()
+	if d.schedule, err = global.Compile(); err != nil {
+		return fmt.Errorf("maintenance: %w", err)
+	}
+	if d.rules, err = rm.TargetRules(annotations, global); err != nil {
+		return err
+	}
+	if d.scanner, err = scan.Load(); err != nil {
+		return err
+	}
+	if d.fulcio, err = watcher.LoadFulcioRoots(); err != nil {
+		return err
+	}
+	if d.rekor, err = watcher.LoadRekorKeys(); err != nil {
+		return err
+	}
+	d.cosign = watcher.LoadCosignKeys()
+	wcfg := config.GetWatcherPreferences()
+	if wcfg.Platform != "" {
+		// a bad default would make every platform-tracking check fail quietly
+		if _, err := watcher.ParsePlatform(wcfg.Platform); err != nil {
+			return fmt.Errorf("MD_PLATFORM: %w", err)
+		}
+	}
+	d.st = st
+	// one set of clients for the watcher and the provenance checks, so
+	// both share tokens and per-registry rate limits
+	d.regs = watcher.NewRegistries()
+
+	// 5. Initial run, unless frozen
+	if frozen, why := d.schedule.Frozen(time.Now()); frozen {
+		log.Printf("[schedule] %s, skipping initial reconcile", why)
+	} else {
+		paths := rm.BuildReconcilePaths(annotations)
+		reconciler.RunAll(ctx, os.Getenv("MD_RECONCILE_SCRIPT"), rm.Path, paths)
+	}
 
-	// 5. Create and start watcher with current targets
+	// 6. Create and start watcher with current targets
 	go d.consume(ctx, rm)
-	w := watcher.New(targets, d.EventsEmitter())
+	w := watcher.NewFromConfig(watcher.WatcherConfig{
+		PollInterval: wcfg.PollInterval,
+		Workers:      wcfg.PollWorkers,
+		Platform:     wcfg.Platform,
+		Targets:      targets,
+		CosignKeys:   d.cosign,
+		Registries:   d.regs,
+	}, d.EventsEmitter())
 	return w.Start(ctx, st)
 }
@@ -0,0 +1,23 @@
+package daemon
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRetryAfter(t *testing.T) {
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{1, retryDelay},
+		{2, 2 * retryDelay},
+		{3, 4 * retryDelay},
+		{20, maxRetryDelay},
+	}
+	for _, tc := range tests {
+		if got := retryAfter(tc.attempts); got != tc.want {
+			t.Fatalf("retryAfter(%d) = %s, want %s", tc.attempts, got, tc.want)
+		}
+	}
+}
@@ -13,6 +13,8 @@ import (
 
 	"github.com/jpvargasdev/magos-dominus/internal/config"
 	"github.com/jpvargasdev/magos-dominus/internal/github"
+	"github.com/jpvargasdev/magos-dominus/internal/maintenance"
+	"github.com/jpvargasdev/magos-dominus/internal/policy"
 	"github.com/jpvargasdev/magos-dominus/internal/watcher"
 )
 
@@ -22,10 +24,21 @@ type RepoManager struct {
 }
 
 type MagosAnnotation struct {
-	File   string
-	Line   int
-	Image  string
-	Policy string
+	File     string
+	Line     int
+	Image    string
+	Policy   string
+	Interval int // poll interval in seconds, 0 = global default
+	// Platform selects one image of a multi-arch index ("linux/arm64", "host").
+	Platform    string
+	PinPlatform bool
+	MinAge      time.Duration // only adopt images built at least this long ago
+	// Provenance, when set, is the SLSA provenance a digest needs to be deployed.
+	Provenance watcher.ProvenancePolicy
+	// Maintenance restricts when updates are applied (window, timezone, freeze).
+	Maintenance maintenance.Spec
+	// Options narrow the tags the policy may pick (semver range, ...).
+	Options policy.Options
 }
 
 func NewRepoManager() *RepoManager {
@@ -54,10 +67,51 @@ func (r *RepoManager) SyncFresh() error {
 	return r.Sync()
 }
 
+// denyListFile is the repository-wide skip list, relative to the repo root.
+const denyListFile = "magos-deny.json"
+
+// loadDenyList reads denyListFile, keyed by imageKey; a missing file is an
+// empty list.
+func (r *RepoManager) loadDenyList() (map[string][]string, error) {
+	data, err := os.ReadFile(filepath.Join(r.Path, denyListFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	dl, err := policy.ParseDenyList(data)
+	if err != nil {
+		return nil, err
+	}
+	out := make(map[string][]string, len(dl))
+	for image, entries := range dl {
+		k := imageKey(image)
+		out[k] = append(out[k], entries...)
+	}
+	return out, nil
+}
+
+// imageKey is "registry/owner/name" of an image reference, without its tag.
+func imageKey(img string) string {
+	registry, owner, name, _ := splitImageRef(img)
+	if isDockerHub(registry) {
+		registry = "docker.io"
+	}
+	return strings.ToLower(registry + "/" + owner + "/" + name)
+}
+
 func (r *RepoManager) ParseMagosAnnotations() ([]MagosAnnotation, error) {
 	var out []MagosAnnotation
 
-	err := filepath.WalkDir(r.Path, func(path string, d os.DirEntry, err error) error {
+	// A deny list that cannot be read must not let known-broken releases
+	// through, so it fails the whole parse.
+	deny, err := r.loadDenyList()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", denyListFile, err)
+	}
+
+	err = filepath.WalkDir(r.Path, func(path string, d os.DirEntry, err error) error {
 		if err != nil {
 			return err
 		}
@@ -106,23 +160,92 @@ func (r *RepoManager) ParseMagosAnnotations() ([]MagosAnnotation, error) {
 
 			var payload struct {
 				Magos struct {
-					Policy string `json:"policy"`
-					Note   string `json:"note"`
+					Policy      string                   `json:"policy"`
+					Note        string                   `json:"note"`
+					Interval    string                   `json:"interval"`
+					Platform    string                   `json:"platform"`
+					PinPlatform bool                     `json:"pinPlatform"`
+					MinAge      string                   `json:"minAge"`
+					Provenance  watcher.ProvenancePolicy `json:"provenance"`
+					policy.Options
+					maintenance.Spec
 				} `json:"magos"`
 			}
 			if err := json.Unmarshal([]byte(raw), &payload); err != nil {
 				continue
 			}
-			policy := strings.TrimSpace(payload.Magos.Policy)
-			if policy == "" {
-				policy = "manual"
+			pol := strings.TrimSpace(payload.Magos.Policy)
+			if pol == "" {
+				pol = "manual"
+			}
+			// An unknown policy would otherwise just follow the tag.
+			p, err := policy.Lookup(pol)
+			if err != nil {
+				log.Printf("[repo] %s:%d: skipping annotation: %v", path, ln, err)
+				continue
+			}
+			pol = p.Name()
+			// A range that cannot be evaluated must not silently widen to
+			// "any version", so the whole annotation is dropped.
+			if err := payload.Magos.Options.Validate(); err != nil {
+				log.Printf("[repo] %s:%d: skipping annotation: %v", path, ln, err)
+				continue
+			}
+			// Same for a window that cannot be evaluated: it would otherwise
+			// let updates through at any time.
+			if _, err := payload.Magos.Spec.Compile(); err != nil {
+				log.Printf("[repo] %s:%d: skipping annotation: %v", path, ln, err)
+				continue
+			}
+			if err := p.Validate(payload.Magos.Options); err != nil {
+				log.Printf("[repo] %s:%d: skipping annotation: %v", path, ln, err)
+				continue
+			}
+
+			if extra := deny[imageKey(img)]; len(extra) > 0 {
+				payload.Magos.Skip = append(payload.Magos.Skip, extra...)
+			}
+
+			interval := 0
+			if raw := strings.TrimSpace(payload.Magos.Interval); raw != "" {
+				d, err := time.ParseDuration(raw)
+				if err != nil || d <= 0 {
+					log.Printf("[repo] %s:%d: ignoring bad interval %q", path, ln, raw)
+				} else {
+					interval = int(d / time.Second)
+				}
+			}
+
+			platform := strings.TrimSpace(payload.Magos.Platform)
+			if platform != "" {
+				if _, err := watcher.ParsePlatform(platform); err != nil {
+					log.Printf("[repo] %s:%d: ignoring %v", path, ln, err)
+					platform = ""
+				}
+			}
+
+			var minAge time.Duration
+			if raw := strings.TrimSpace(payload.Magos.MinAge); raw != "" {
+				d, err := time.ParseDuration(raw)
+				if err != nil || d < 0 {
+					log.Printf("[repo] %s:%d: ignoring bad minAge %q", path, ln, raw)
+				} else {
+					minAge = d
+				}
 			}
 
 			out = append(out, MagosAnnotation{
-				File:   path,
-				Line:   ln,
-				Image:  img,
-				Policy: policy,
+				File:        path,
+				Line:        ln,
+				Image:       img,
+				Policy:      pol,
+				Interval:    interval,
+				Platform:    platform,
+				PinPlatform: payload.Magos.PinPlatform,
+				MinAge:      minAge,
+				Provenance:  payload.Magos.Provenance,
+				Maintenance: payload.Magos.Spec,
+				Options:     payload.Magos.Options,
 			})
 		}
 		return sc.Err()
@@ -162,28 +285,89 @@ func (r *RepoManager) BuildReconcilePaths(annos []MagosAnnotation) []watcher.Tar
 func (r *RepoManager) BuildTargets(annos []MagosAnnotation) []watcher.Target {
 	var targets []watcher.Target
 	for _, a := range annos {
-		if a.Policy == "manual" {
+		// policies that never rewrite the image need no watching
+		if p, err := policy.Lookup(a.Policy); err != nil || p.Pin() == policy.PinNone {
 			continue
 		}
 		registry, owner, name, tag := splitImageRef(a.Image)
+		if name == "" {
+			log.Printf("[repo] %s:%d: skipping unparseable image %q", a.File, a.Line, a.Image)
+			continue
+		}
 		targets = append(targets, watcher.Target{
 			Name: a.File,
+			Line: a.Line,
 			Image: watcher.ImageRef{
 				Registry: registry,
 				Owner:    owner,
 				Name:     name,
 				Tag:      tag,
 			},
-			Policy:   a.Policy,
-			Interval: 0,
+			Policy:      a.Policy,
+			Interval:    a.Interval,
+			Platform:    a.Platform,
+			PinPlatform: a.PinPlatform,
+			MinAge:      a.MinAge,
+			Options:     a.Options,
 		})
 	}
 	return targets
 }
 
+// TargetRule is what the daemon checks an update event against before
+// applying it.
+type TargetRule struct {
+	Provenance watcher.ProvenancePolicy
+	Schedule   *maintenance.Schedule // nil: the global schedule
+}
+
+// TargetRules maps annotations keyed by targetKey to their rules. Schedules
+// are the annotation's maintenance settings layered over global.
+func (r *RepoManager) TargetRules(annos []MagosAnnotation, global maintenance.Spec) (map[string]TargetRule, error) {
+	rules := make(map[string]TargetRule)
+	for _, a := range annos {
+		if a.Provenance.IsZero() && a.Maintenance.IsZero() {
+			continue
+		}
+		rule := TargetRule{Provenance: a.Provenance}
+		if !a.Maintenance.IsZero() {
+			sc, err := a.Maintenance.Over(global).Compile()
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: %w", a.File, a.Line, err)
+			}
+			rule.Schedule = sc
+		}
+		rules[targetKey(a.File, a.Line)] = rule
+	}
+	return rules, nil
+}
+
+// targetKey identifies an annotated image line the way update events do:
+// by file and line, so two watchers of one image in a file stay apart.
+func targetKey(file string, line int) string {
+	return fmt.Sprintf("%s:%d", file, line)
+}
+
 func splitImageRef(img string) (string, string, string, string) {
 	// supports something like ghcr.io/repo/app:0.0.1
 	parts := strings.SplitN(img, "/", 3)
+	switch {
+	case len(parts) == 1 && parts[0] != "":
+		// official image: postgres:16 -> docker.io/library/postgres
+		parts = []string{"docker.io", "library", parts[0]}
+	case len(parts) == 3 && !isRegistryHost(parts[0]):
+		// Docker Hub without a registry: acme/team/app
+		parts = []string{"docker.io", parts[0], parts[1] + "/" + parts[2]}
+	case len(parts) == 2 && !isRegistryHost(parts[0]):
+		// Docker Hub short form: linuxserver/sonarr:latest
+		parts = []string{"docker.io", parts[0], parts[1]}
+	case len(parts) == 2 && isDockerHub(parts[0]):
+		// official image: docker.io/postgres:16 -> docker.io/library/postgres
+		parts = []string{parts[0], "library", parts[1]}
+	case len(parts) == 2:
+		// self-hosted registry without a namespace: registry.lan:5000/app
+		parts = []string{parts[0], "", parts[1]}
+	}
 	if len(parts) < 3 {
 		return "", "", "", ""
 	}
@@ -196,7 +380,23 @@ func splitImageRef(img string) (string, string, string, string) {
 	return registry, owner, name, tag
 }
 
-func (r *RepoManager) CommitAndPush(absPath string, preferPR bool) error {
+// isRegistryHost applies the docker CLI rule: the first path component is a
+// registry only if it looks like a hostname (has a dot or port, or is localhost).
+func isRegistryHost(s string) bool {
+	return strings.ContainsAny(s, ".:") || s == "localhost"
+}
+
+func isDockerHub(host string) bool {
+	switch strings.ToLower(host) {
+	case "docker.io", "index.docker.io", "registry-1.docker.io":
+		return true
+	}
+	return false
+}
+
+// CommitAndPush commits absPath; a non-empty note becomes the body of the
+// commit message.
+func (r *RepoManager) CommitAndPush(absPath, note string, preferPR bool) error {
 	ctx := context.Background()
 	ghCfg := config.GetGithubConfig()
 	gh := github.New(ghCfg.AppId, ghCfg.InstallationId, ghCfg.PrivateKeyPath, ghCfg.RepoURL)
@@ -224,6 +424,9 @@ func (r *RepoManager) CommitAndPush(absPath string, preferPR bool) error {
 
 	// 4) commit firmado por la App (vía API)
 	msg := fmt.Sprintf("magos: update %s", relPath)
+	if note != "" {
+		msg += "\n\n" + note
+	}
 	if _, err := gh.UpdateFileSigned(ctx, relPath, branch, msg, content); err != nil {
 		return fmt.Errorf("update file via API: %w", err)
 	}
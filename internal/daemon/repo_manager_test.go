@@ -6,7 +6,9 @@ import (
 	"runtime"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/jpvargasdev/magos-dominus/internal/maintenance"
 	"github.com/jpvargasdev/magos-dominus/internal/watcher"
 )
 
@@ -40,8 +42,16 @@ func TestSplitImageRef(t *testing.T) {
 		{"ghcr.io/jpvargasdev/lexcodex:0.0.3", "ghcr.io", "jpvargasdev", "lexcodex", "0.0.3"},
 		{"ghcr.io/owner/app:latest", "ghcr.io", "owner", "app", "latest"},
 		{"ghcr.io/owner/app", "ghcr.io", "owner", "app", "latest"},
-		{"badformat", "", "", "", ""},
+		{"postgres:16", "docker.io", "library", "postgres", "16"},
+		{"redis", "docker.io", "library", "redis", "latest"},
+		{"nginx:1.25.3-alpine", "docker.io", "library", "nginx", "1.25.3-alpine"},
+		{"", "", "", "", ""},
 		{"ghcr.io/only/two", "ghcr.io", "only", "two", "latest"},
+		{"docker.io/library/postgres:16.4", "docker.io", "library", "postgres", "16.4"},
+		{"docker.io/postgres:16.4", "docker.io", "library", "postgres", "16.4"},
+		{"linuxserver/sonarr:latest", "docker.io", "linuxserver", "sonarr", "latest"},
+		{"registry.lan:5000/homepage:1.0", "registry.lan:5000", "", "homepage", "1.0"},
+		{"quay.io/prometheus/node-exporter:v1.8.2", "quay.io", "prometheus", "node-exporter", "v1.8.2"},
 	}
 
 	for _, tc := range tests {
@@ -117,10 +127,159 @@ services:
 	}
 }
 
+func TestParseMagosAnnotations_Interval(t *testing.T) {
+	tmp := t.TempDir()
+
+	yml := `
+services:
+  app:
+    image: ghcr.io/owner/app:1.0.0 # {"magos":{"policy":"semver","interval":"1m"}}
+  base:
+    image: docker.io/library/postgres:16.4 # {"magos":{"policy":"semver","interval":"1h"}}
+  bad:
+    image: ghcr.io/owner/bad:1.0.0 # {"magos":{"policy":"semver","interval":"soon"}}
+`
+	_ = writeFile(t, tmp, "s/compose.yml", strings.TrimLeft(yml, "\n"))
+
+	rm := &RepoManager{Path: tmp}
+	annos, err := rm.ParseMagosAnnotations()
+	if err != nil {
+		t.Fatalf("ParseMagosAnnotations error: %v", err)
+	}
+	if len(annos) != 3 {
+		t.Fatalf("expected 3 annotations, got %d", len(annos))
+	}
+	for i, want := range []int{60, 3600, 0} {
+		if annos[i].Interval != want {
+			t.Fatalf("annotation %d interval = %d, want %d", i, annos[i].Interval, want)
+		}
+	}
+
+	targets := rm.BuildTargets(annos)
+	if targets[1].Interval != 3600 {
+		t.Fatalf("BuildTargets dropped interval: %d", targets[1].Interval)
+	}
+}
+
+func TestParseMagosAnnotations_MinAge(t *testing.T) {
+	tmp := t.TempDir()
+
+	yml := `
+services:
+  app:
+    image: ghcr.io/owner/app:1.0.0 # {"magos":{"policy":"semver","minAge":"72h"}}
+  bad:
+    image: ghcr.io/owner/bad:1.0.0 # {"magos":{"policy":"semver","minAge":"3 days"}}
+`
+	_ = writeFile(t, tmp, "s/compose.yml", strings.TrimLeft(yml, "\n"))
+
+	rm := &RepoManager{Path: tmp}
+	annos, err := rm.ParseMagosAnnotations()
+	if err != nil {
+		t.Fatalf("ParseMagosAnnotations error: %v", err)
+	}
+	if len(annos) != 2 || annos[0].MinAge != 72*time.Hour || annos[1].MinAge != 0 {
+		t.Fatalf("unexpected minAge values: %+v", annos)
+	}
+	if targets := rm.BuildTargets(annos); targets[0].MinAge != 72*time.Hour {
+		t.Fatalf("BuildTargets dropped minAge: %s", targets[0].MinAge)
+	}
+}
+
+func TestParseMagosAnnotations_Range(t *testing.T) {
+	tmp := t.TempDir()
+
+	yml := `
+services:
+  db:
+    image: docker.io/library/postgres:16.4 # {"magos":{"policy":"semver","range":">=16.0.0 <17.0.0"}}
+  bad:
+    image: ghcr.io/owner/bad:1.0.0 # {"magos":{"policy":"semver","range":"sixteen"}}
+`
+	_ = writeFile(t, tmp, "s/compose.yml", strings.TrimLeft(yml, "\n"))
+
+	rm := &RepoManager{Path: tmp}
+	annos, err := rm.ParseMagosAnnotations()
+	if err != nil {
+		t.Fatalf("ParseMagosAnnotations error: %v", err)
+	}
+	if len(annos) != 1 {
+		t.Fatalf("bad range should drop the annotation, got %d annotations", len(annos))
+	}
+	targets := rm.BuildTargets(annos)
+	if targets[0].Options.Range != ">=16.0.0 <17.0.0" {
+		t.Fatalf("range not carried to the target: %+v", targets[0].Options)
+	}
+}
+
+func TestParseMagosAnnotations_RegexPolicy(t *testing.T) {
+	tmp := t.TempDir()
+
+	yml := `
+services:
+  minio:
+    image: docker.io/minio/minio:RELEASE.2024-04-18T19-09-19Z # {"magos":{"policy":"regex","filter":"^RELEASE\\.(?P<ts>.+)$","order":"alphabetical"}}
+  nofilter:
+    image: ghcr.io/owner/app:main-1 # {"magos":{"policy":"regex"}}
+  noformat:
+    image: ghcr.io/home-assistant/home-assistant:2024.10.3 # {"magos":{"policy":"calver"}}
+  badorder:
+    image: ghcr.io/owner/app:main-1 # {"magos":{"policy":"regex","filter":"^main-(?P<n>\\d+)$","order":"newest"}}
+`
+	_ = writeFile(t, tmp, "s/compose.yml", strings.TrimLeft(yml, "\n"))
+
+	rm := &RepoManager{Path: tmp}
+	annos, err := rm.ParseMagosAnnotations()
+	if err != nil {
+		t.Fatalf("ParseMagosAnnotations error: %v", err)
+	}
+	if len(annos) != 1 {
+		t.Fatalf("want only the valid regex annotation, got %d", len(annos))
+	}
+	if got := annos[0].Options; got.Filter != `^RELEASE\.(?P<ts>.+)$` || got.Order != "alphabetical" {
+		t.Fatalf("unexpected options: %+v", got)
+	}
+}
+
+func TestParseMagosAnnotations_SkipAndDenyList(t *testing.T) {
+	tmp := t.TempDir()
+
+	yml := `
+services:
+  db:
+    image: docker.io/postgres:16.4.0 # {"magos":{"policy":"semver","skip":["16.5.0"]}}
+  api:
+    image: ghcr.io/acme/api:1.4.0 # {"magos":{"policy":"semver"}}
+`
+	_ = writeFile(t, tmp, "s/compose.yml", strings.TrimLeft(yml, "\n"))
+	_ = writeFile(t, tmp, denyListFile, `{"docker.io/postgres": [">=17.0.0 <17.0.2"], "ghcr.io/acme/api": ["1.4.2"]}`)
+
+	rm := &RepoManager{Path: tmp}
+	annos, err := rm.ParseMagosAnnotations()
+	if err != nil {
+		t.Fatalf("ParseMagosAnnotations error: %v", err)
+	}
+	if len(annos) != 2 {
+		t.Fatalf("expected 2 annotations, got %d", len(annos))
+	}
+	if got := strings.Join(annos[0].Options.Skip, "|"); got != "16.5.0|>=17.0.0 <17.0.2" {
+		t.Fatalf("db skip = %q", got)
+	}
+	if got := strings.Join(annos[1].Options.Skip, "|"); got != "1.4.2" {
+		t.Fatalf("api skip = %q", got)
+	}
+
+	_ = writeFile(t, tmp, denyListFile, `{"ghcr.io/acme/api": [">= nope"]}`)
+	if _, err := rm.ParseMagosAnnotations(); err == nil {
+		t.Fatalf("expected a broken deny list to fail the parse")
+	}
+}
+
 func TestBuildTargets_MapsFieldsAndSkipsManual(t *testing.T) {
 	annos := []MagosAnnotation{
 		{
 			File:   "/tmp/git/stacks/lexcodex/lexcodex-compose.yml",
+			Line:   4,
 			Image:  "ghcr.io/jpvargasdev/lexcodex:0.0.3",
 			Policy: "semver",
 		},
@@ -129,21 +288,26 @@ func TestBuildTargets_MapsFieldsAndSkipsManual(t *testing.T) {
 			Image:  "ghcr.io/owner/skip:1.2.3",
 			Policy: "manual", // should be skipped
 		},
+		{
+			File:   "/tmp/git/stacks/broken/compose.yml",
+			Image:  "ghcr.io/",
+			Policy: "semver", // unparseable, should be skipped
+		},
 	}
 
 	rm := &RepoManager{Path: "/tmp/git"}
 	targets := rm.BuildTargets(annos)
 
 	if len(targets) != 1 {
-		t.Fatalf("expected 1 target (manual skipped), got %d", len(targets))
+		t.Fatalf("expected 1 target (manual and unparseable skipped), got %d", len(targets))
 	}
 
 	t0 := targets[0]
 	if got, want := t0.Name, "/tmp/git/stacks/lexcodex/lexcodex-compose.yml"; got != want {
 		t.Fatalf("Name mismatch: got %q want %q", got, want)
 	}
-	if t0.Policy != "semver" {
-		t.Fatalf("Policy mismatch: %q", t0.Policy)
+	if t0.Policy != "semver" || t0.Line != 4 {
+		t.Fatalf("Policy or Line mismatch: %q, %d", t0.Policy, t0.Line)
 	}
 	if t0.Interval != 0 {
 		t.Fatalf("Interval should be 0, got %d", t0.Interval)
@@ -159,3 +323,105 @@ func TestBuildTargets_MapsFieldsAndSkipsManual(t *testing.T) {
 		t.Fatalf("ImageRef mismatch:\n got: %#v\nwant: %#v", t0.Image, wantImg)
 	}
 }
+
+func TestTargetRules(t *testing.T) {
+	tmp := t.TempDir()
+
+	yml := `
+services:
+  api:
+    image: ghcr.io/Acme/api:1.4.0 # {"magos":{"policy":"semver","provenance":{"builder":"https://github.com/actions/runner/github-hosted","source":"github.com/acme/api"}}}
+  web:
+    image: ghcr.io/acme/web:2.0.0 # {"magos":{"policy":"semver"}}
+  jellyfin:
+    image: docker.io/jellyfin/jellyfin:10.9.0 # {"magos":{"policy":"semver","window":"* 2-5 * * *","timezone":"Europe/Madrid","freeze":["2026-12-20/2027-01-06"]}}
+  broken:
+    image: ghcr.io/acme/broken:1.0.0 # {"magos":{"policy":"semver","window":"* 25 * * *"}}
+`
+	fp := writeFile(t, tmp, "s/compose.yml", strings.TrimLeft(yml, "\n"))
+
+	rm := &RepoManager{Path: tmp}
+	annos, err := rm.ParseMagosAnnotations()
+	if err != nil {
+		t.Fatalf("ParseMagosAnnotations error: %v", err)
+	}
+	if len(annos) != 3 {
+		t.Fatalf("expected the broken window to drop its annotation, got %d annotations", len(annos))
+	}
+	rules, err := rm.TargetRules(annos, maintenance.Spec{Window: "* 0-6 * * *"})
+	if err != nil {
+		t.Fatalf("TargetRules error: %v", err)
+	}
+	if len(rules) != 2 {
+		t.Fatalf("expected 2 rules, got %v", rules)
+	}
+	rule, ok := rules[targetKey(fp, 3)]
+	if !ok || rule.Provenance.Builder != "https://github.com/actions/runner/github-hosted" || rule.Provenance.Source != "github.com/acme/api" || rule.Schedule != nil {
+		t.Fatalf("unexpected api rule: %+v", rule)
+	}
+
+	sc := rules[targetKey(fp, 7)].Schedule
+	madrid, _ := time.LoadLocation("Europe/Madrid")
+	if open, _ := sc.Open(time.Date(2026, 10, 16, 15, 0, 0, 0, madrid)); open {
+		t.Fatalf("jellyfin should not update at 15:00")
+	}
+	if open, _ := sc.Open(time.Date(2026, 10, 16, 3, 0, 0, 0, madrid)); !open {
+		t.Fatalf("jellyfin should update at 03:00")
+	}
+	if open, why := sc.Open(time.Date(2026, 12, 24, 3, 0, 0, 0, madrid)); open || !strings.Contains(why, "frozen") {
+		t.Fatalf("jellyfin should be frozen over the holidays: %q", why)
+	}
+}
+
+func TestTargetRules_SameImageTwice(t *testing.T) {
+	tmp := t.TempDir()
+	fp := writeFile(t, tmp, "compose.yml", `services:
+  legacy-db:
+    image: postgres:15.7 # {"magos":{"policy":"semver","range":"~15"}}
+  db:
+    image: postgres:16.3 # {"magos":{"policy":"semver","range":"~16","window":"* 1-5 * * *"}}
+`)
+	rm := &RepoManager{Path: tmp}
+	annos, err := rm.ParseMagosAnnotations()
+	if err != nil {
+		t.Fatalf("ParseMagosAnnotations error: %v", err)
+	}
+	targets := rm.BuildTargets(annos)
+	if len(targets) != 2 || targets[0].Line != 3 || targets[1].Line != 5 {
+		t.Fatalf("want one target per line, got %+v", targets)
+	}
+	rules, err := rm.TargetRules(annos, maintenance.Spec{})
+	if err != nil {
+		t.Fatalf("TargetRules error: %v", err)
+	}
+	if _, ok := rules[targetKey(fp, 3)]; ok {
+		t.Fatal("the 15.x line has no rule of its own")
+	}
+	if rules[targetKey(fp, 5)].Schedule == nil {
+		t.Fatal("the 16.x line should keep its window")
+	}
+}
+
+func TestParseMagosAnnotations_RejectsUnknownPolicy(t *testing.T) {
+	tmp := t.TempDir()
+
+	yml := `
+services:
+  api:
+    image: ghcr.io/acme/api:1.4.0 # {"magos":{"policy":"semvar"}}
+  web:
+    image: ghcr.io/acme/web:2.0.0 # {"magos":{"policy":"SemVer"}}
+  tools:
+    image: ghcr.io/acme/tools:main # {"magos":{"policy":"regex"}}
+`
+	_ = writeFile(t, tmp, "s/compose.yml", strings.TrimLeft(yml, "\n"))
+
+	rm := &RepoManager{Path: tmp}
+	annos, err := rm.ParseMagosAnnotations()
+	if err != nil {
+		t.Fatalf("ParseMagosAnnotations error: %v", err)
+	}
+	if len(annos) != 1 || annos[0].Image != "ghcr.io/acme/web:2.0.0" || annos[0].Policy != "semver" {
+		t.Fatalf("expected only the web annotation, with its policy name normalized, got %+v", annos)
+	}
+}
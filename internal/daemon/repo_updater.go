@@ -4,9 +4,24 @@ import (
   "fmt"
   "os"
   "strings"
+
+  "github.com/jpvargasdev/magos-dominus/internal/policy"
 )
 
-func (r *RepoManager) UpdateImage(filePath, newRef, newDigest string, policy string) (bool, error) {
+// UpdateImage rewrites the annotated image line of filePath that an update
+// event is for, found by imageLine from the event's repository ("owner/name")
+// and line, to newRef or newDigest depending on how the policy pins. Other
+// lines of the same image, watched with other settings, are left alone. It
+// reports whether the file changed.
+func (r *RepoManager) UpdateImage(filePath, repo string, line int, newRef, newDigest string, policyName string) (bool, error) {
+	p, err := policy.Lookup(policyName)
+	if err != nil {
+		return false, err
+	}
+	if p.Pin() == policy.PinNone {
+		return false, fmt.Errorf("policy %q does not rewrite images", p.Name())
+	}
+
 	// 1) read file
 	src, err := os.ReadFile(filePath)
 	if err != nil {
@@ -14,64 +29,41 @@ func (r *RepoManager) UpdateImage(filePath, newRef, newDigest string, policy str
 	}
 	lines := strings.Split(string(src), "\n")
 
-	// 2) scan for the image line that also carries the magos annotation
-	updated := false
-	for i, line := range lines {
-		if !strings.Contains(line, "image:") || !strings.Contains(line, `{"magos"`) {
-			continue
-		}
-
-		left, right, ok := strings.Cut(line, "#")
-		if !ok {
-			continue
-		}
+	// 2) find the annotated image line of the event
+	i, err := imageLine(lines, repo, line)
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", filePath, err)
+	}
+	if i < 0 {
+		return false, nil
+	}
+	prefix, cur, right, _ := annotatedImage(lines[i])
 
-		// extract current image ref from "image: <ref>"
-		imgField := strings.TrimRight(left, " \t")
-		idx := strings.Index(imgField, "image:")
-		if idx < 0 {
-			continue
-		}
-		prefix := imgField[:idx]                 // keep original indentation/prefix
-		rest := strings.TrimSpace(imgField[idx:]) // starts with "image:"
-		cur := strings.TrimSpace(strings.TrimPrefix(rest, "image:"))
-		if cur == "" {
-			continue
-		}
+	// base repo (strip tag or digest)
+	base := stripRefOrDigest(cur)
 
-		// base repo (strip tag or digest)
-		base := stripRefOrDigest(cur)
-
-		// build desired ref
-		var desired string
-		if policy == "digest" {
-			if !strings.HasPrefix(newDigest, "sha256:") {
-				return false, fmt.Errorf("invalid digest %q", newDigest)
-			}
-			desired = fmt.Sprintf("%s@%s", base, newDigest)
-		} else {
-			if newRef == "" {
-				return false, fmt.Errorf("empty ref")
-			}
-			desired = fmt.Sprintf("%s:%s", base, newRef)
+	// build desired ref
+	var desired string
+	if p.Pin() == policy.PinDigest {
+		if !strings.HasPrefix(newDigest, "sha256:") {
+			return false, fmt.Errorf("invalid digest %q", newDigest)
 		}
-
-		// idempotency: already desired
-		if normalizeImage(cur) == normalizeImage(desired) {
-			continue
+		desired = fmt.Sprintf("%s@%s", base, newDigest)
+	} else {
+		if newRef == "" {
+			return false, fmt.Errorf("empty ref")
 		}
-
-		// recompose line, preserving prefix and annotation tail
-		newLine := fmt.Sprintf("%simage: %s #%s", prefix, desired, right)
-		lines[i] = newLine
-		updated = true
-		break // remove if you want to update multiple services in one file
+		desired = fmt.Sprintf("%s:%s", base, newRef)
 	}
 
-	if !updated {
+	// idempotency: already desired
+	if normalizeImage(cur) == normalizeImage(desired) {
 		return false, nil
 	}
 
+	// recompose line, preserving prefix and annotation tail
+	lines[i] = fmt.Sprintf("%simage: %s #%s", prefix, desired, right)
+
 	// 3) write back atomically
 	tmp := filePath + ".tmp"
 	if err := os.WriteFile(tmp, []byte(strings.Join(lines, "\n")), 0o644); err != nil {
@@ -83,6 +75,88 @@ func (r *RepoManager) UpdateImage(filePath, newRef, newDigest string, policy str
 	return true, nil
 }
 
+// CurrentImage returns the image reference on the annotated image line of
+// filePath that UpdateImage rewrites for repo and line.
+func (r *RepoManager) CurrentImage(filePath, repo string, line int) (string, error) {
+	src, err := os.ReadFile(filePath)
+	if err != nil {
+		return "", err
+	}
+	lines := strings.Split(string(src), "\n")
+	i, err := imageLine(lines, repo, line)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", filePath, err)
+	}
+	if i < 0 {
+		return "", fmt.Errorf("%s: no annotated image line for %s", filePath, repo)
+	}
+	_, cur, _, _ := annotatedImage(lines[i])
+	return cur, nil
+}
+
+// imageLine returns the index in lines of the annotated image line of repo
+// that an update is for: line (1-based, where its annotation was read) when
+// that still holds repo, otherwise the only annotated line of repo, since
+// the file may have moved since it was parsed. It returns -1 when repo has
+// no annotated line and an error when several could be meant. A line of 0
+// is only found when it is the only one.
+func imageLine(lines []string, repo string, line int) (int, error) {
+	holds := func(l string) bool {
+		_, cur, _, ok := annotatedImage(l)
+		return ok && strings.EqualFold(imageRepository(cur), repo)
+	}
+	if line > 0 && line <= len(lines) && holds(lines[line-1]) {
+		return line - 1, nil
+	}
+	found := -1
+	for i, l := range lines {
+		if !holds(l) {
+			continue
+		}
+		if found >= 0 {
+			return -1, fmt.Errorf("line %d is no longer the annotated %s line and several others are", line, repo)
+		}
+		found = i
+	}
+	return found, nil
+}
+
+// imageRepository returns the "owner/name" of img, the form update events
+// carry.
+func imageRepository(img string) string {
+	_, owner, name, _ := splitImageRef(stripRefOrDigest(img))
+	if owner == "" {
+		return name
+	}
+	return owner + "/" + name
+}
+
+// annotatedImage splits an "image: <ref> # {"magos":...}" line into the text
+// before "image:", the image reference and the annotation after "#".
+func annotatedImage(line string) (prefix, image, annotation string, ok bool) {
+	if !strings.Contains(line, "image:") || !strings.Contains(line, `{"magos"`) {
+		return "", "", "", false
+	}
+
+	left, right, ok := strings.Cut(line, "#")
+	if !ok {
+		return "", "", "", false
+	}
+
+	// extract current image ref from "image: <ref>"
+	imgField := strings.TrimRight(left, " \t")
+	idx := strings.Index(imgField, "image:")
+	if idx < 0 {
+		return "", "", "", false
+	}
+	prefix = imgField[:idx]                   // keep original indentation/prefix
+	rest := strings.TrimSpace(imgField[idx:]) // starts with "image:"
+	image = strings.TrimSpace(strings.TrimPrefix(rest, "image:"))
+	if image == "" {
+		return "", "", "", false
+	}
+	return prefix, image, right, true
+}
 
 // stripRefOrDigest returns "registry/owner/name" from an image like
 // "ghcr.io/owner/name:tag" or "ghcr.io/owner/name@sha256:...".
@@ -38,7 +38,7 @@ services:
 	ref := "0.0.4"
 	digest := "sha256:deadbeefcafebabe0123456789abcdef0123456789abcdef0123456789abcd"
 
-	updated, err := rm.UpdateImage(fp, ref, digest, "digest") // useDigest=true
+	updated, err := rm.UpdateImage(fp, "jpvargasdev/lexcodex", 0, ref, digest, "digest") // useDigest=true
 	if err != nil {
 		t.Fatalf("UpdateImage error: %v", err)
 	}
@@ -65,7 +65,7 @@ services:
 	fp := writeTemp(t, tmp, "compose.yml", strings.TrimLeft(orig, "\n"))
 
 	rm := &RepoManager{Path: tmp}
-	updated, err := rm.UpdateImage(fp, "0.0.4", "", "semver") // useDigest=false
+	updated, err := rm.UpdateImage(fp, "jpvargasdev/lexcodex", 0, "0.0.4", "", "semver") // useDigest=false
 	if err != nil {
 		t.Fatalf("UpdateImage error: %v", err)
 	}
@@ -89,7 +89,7 @@ services:
 	fp := writeTemp(t, tmp, "compose.yml", strings.TrimLeft(orig, "\n"))
 
 	rm := &RepoManager{Path: tmp}
-	updated, err := rm.UpdateImage(fp, "ignored", "sha256:deadbeef", "digest")
+	updated, err := rm.UpdateImage(fp, "jpvargasdev/lexcodex", 0, "ignored", "sha256:deadbeef", "digest")
 	if err != nil {
 		t.Fatalf("UpdateImage error: %v", err)
 	}
@@ -108,7 +108,7 @@ services:
 	fp := writeTemp(t, tmp, "compose.yml", strings.TrimLeft(orig, "\n"))
 
 	rm := &RepoManager{Path: tmp}
-	updated, err := rm.UpdateImage(fp, "2.0.0", "sha256:xyz", "digest")
+	updated, err := rm.UpdateImage(fp, "some/other", 0, "2.0.0", "sha256:xyz", "digest")
 	if err != nil {
 		t.Fatalf("UpdateImage error: %v", err)
 	}
@@ -127,8 +127,91 @@ services:
 	fp := writeTemp(t, tmp, "compose.yml", strings.TrimLeft(orig, "\n"))
 
 	rm := &RepoManager{Path: tmp}
-	if _, err := rm.UpdateImage(fp, "", "not-a-digest", "digest"); err == nil {
+	if _, err := rm.UpdateImage(fp, "jpvargasdev/lexcodex", 0, "", "not-a-digest", "digest"); err == nil {
 		t.Fatalf("expected error for invalid digest")
 	}
 }
 
+
+func TestUpdateImage_PicksLineByRepo(t *testing.T) {
+	tmp := t.TempDir()
+	fp := writeTemp(t, tmp, "compose.yml", `services:
+  db:
+    image: postgres:16.3 # {"magos":{"policy":"semver"}}
+  api:
+    image: ghcr.io/acme/api:1.0.0 # {"magos":{"policy":"semver"}}
+`)
+	rm := &RepoManager{Path: tmp}
+	updated, err := rm.UpdateImage(fp, "acme/api", 0, "1.1.0", "", "semver")
+	if err != nil || !updated {
+		t.Fatalf("UpdateImage: updated=%v err=%v", updated, err)
+	}
+	got := readFile(t, fp)
+	if !strings.Contains(got, "image: postgres:16.3 #") || !strings.Contains(got, "image: ghcr.io/acme/api:1.1.0 #") {
+		t.Fatalf("wrong line rewritten:\n%s", got)
+	}
+}
+
+func TestUpdateImage_TwoLinesSameRepo(t *testing.T) {
+	tmp := t.TempDir()
+	fp := writeTemp(t, tmp, "compose.yml", `services:
+  legacy-db:
+    image: postgres:15.7 # {"magos":{"policy":"semver","range":"~15"}}
+  db:
+    image: postgres:16.3 # {"magos":{"policy":"semver","range":"~16","window":"* 1-5 * * *"}}
+`)
+	rm := &RepoManager{Path: tmp}
+	updated, err := rm.UpdateImage(fp, "library/postgres", 5, "16.4", "", "semver")
+	if err != nil || !updated {
+		t.Fatalf("UpdateImage: updated=%v err=%v", updated, err)
+	}
+	got := readFile(t, fp)
+	if !strings.Contains(got, "image: postgres:15.7 #") || !strings.Contains(got, "image: postgres:16.4 #") {
+		t.Fatalf("only the 16.x line should change:\n%s", got)
+	}
+	if cur, _ := rm.CurrentImage(fp, "library/postgres", 3); cur != "postgres:15.7" {
+		t.Fatalf("CurrentImage for line 3 = %q", cur)
+	}
+
+	// a line that no longer holds the image cannot say which one is meant
+	if _, err := rm.UpdateImage(fp, "library/postgres", 4, "15.8", "", "semver"); err == nil {
+		t.Fatal("want an error when the line moved and two lines hold the image")
+	}
+}
+
+func TestCurrentImage_LineMoved(t *testing.T) {
+	tmp := t.TempDir()
+	fp := writeTemp(t, tmp, "compose.yml", `services:
+  # a comment added upstream since the annotations were read
+  api:
+    image: ghcr.io/acme/api:1.0.0 # {"magos":{"policy":"semver"}}
+`)
+	rm := &RepoManager{Path: tmp}
+	if cur, err := rm.CurrentImage(fp, "acme/api", 3); err != nil || cur != "ghcr.io/acme/api:1.0.0" {
+		t.Fatalf("the only line of the image should be found: %q, %v", cur, err)
+	}
+}
+
+func TestCurrentImage(t *testing.T) {
+	tmp := t.TempDir()
+	fp := writeTemp(t, tmp, "compose.yml", `services:
+  db:
+    image: postgres:16 # {"magos":{"policy":"semver"}}
+  api:
+    image: ghcr.io/acme/api@sha256:abc # {"magos":{"policy":"digest"}}
+`)
+	rm := &RepoManager{Path: tmp}
+	got, err := rm.CurrentImage(fp, "acme/api", 0)
+	if err != nil {
+		t.Fatalf("CurrentImage error: %v", err)
+	}
+	if got != "ghcr.io/acme/api@sha256:abc" {
+		t.Fatalf("got %q", got)
+	}
+	if got, _ := rm.CurrentImage(fp, "library/postgres", 0); got != "postgres:16" {
+		t.Fatalf("got %q for postgres", got)
+	}
+	if _, err := rm.CurrentImage(fp, "acme/web", 0); err == nil {
+		t.Fatal("want an error without an annotated image line for the repo")
+	}
+}
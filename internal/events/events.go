@@ -7,6 +7,7 @@ import (
 
 type Event struct {
   File       string // Path to YAML File
+  Line       int    // annotated image line in File, 1-based; 0 if unknown
   Repo       string // owner/name
   Ref        string // tag or Ref
   Digest     string // sha256...
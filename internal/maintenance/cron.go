@@ -0,0 +1,152 @@
+package maintenance
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cronField is the set of values one field of a cron expression matches.
+type cronField struct {
+	bits uint64
+	any  bool // "*" (with or without a step), for the day-of-month/day-of-week rule
+}
+
+func (f cronField) has(v int) bool { return f.bits&(1<<uint(v)) != 0 }
+
+// cronExpr is a five-field cron expression (minute hour day-of-month month
+// day-of-week). A minute is inside the window when the expression matches it,
+// so "* 1-5 * * *" is every night from 01:00 to 05:59.
+type cronExpr struct {
+	minute, hour, dom, month, dow cronField
+	loc                           *time.Location // nil: the schedule's zone
+	src                           string
+}
+
+var (
+	monthNames = map[string]int{"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+		"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12}
+	dowNames = map[string]int{"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6}
+)
+
+// parseCron parses one expression, optionally prefixed with
+// "CRON_TZ=<zone> " or "TZ=<zone> ".
+func parseCron(expr string) (*cronExpr, error) {
+	src := strings.TrimSpace(expr)
+	fields := strings.Fields(src)
+	c := &cronExpr{src: src}
+	if len(fields) > 0 {
+		for _, prefix := range []string{"CRON_TZ=", "TZ="} {
+			if zone, ok := strings.CutPrefix(fields[0], prefix); ok {
+				loc, err := time.LoadLocation(zone)
+				if err != nil {
+					return nil, fmt.Errorf("window %q: %w", src, err)
+				}
+				c.loc = loc
+				fields = fields[1:]
+				break
+			}
+		}
+	}
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("window %q: want 5 fields (minute hour day-of-month month day-of-week), got %d", src, len(fields))
+	}
+
+	var err error
+	parse := func(field string, first, last int, names map[string]int) cronField {
+		if err != nil {
+			return cronField{}
+		}
+		var f cronField
+		f, err = parseCronField(field, first, last, names)
+		if err != nil {
+			err = fmt.Errorf("window %q: %w", src, err)
+		}
+		return f
+	}
+	c.minute = parse(fields[0], 0, 59, nil)
+	c.hour = parse(fields[1], 0, 23, nil)
+	c.dom = parse(fields[2], 1, 31, nil)
+	c.month = parse(fields[3], 1, 12, monthNames)
+	c.dow = parse(fields[4], 0, 7, dowNames)
+	if err != nil {
+		return nil, err
+	}
+	if c.dow.has(7) { // both 0 and 7 are Sunday
+		c.dow.bits |= 1
+	}
+	return c, nil
+}
+
+// parseCronField handles "*", "5", "1-5", "mon-fri", lists and "/step".
+func parseCronField(field string, first, last int, names map[string]int) (cronField, error) {
+	var f cronField
+	for _, part := range strings.Split(field, ",") {
+		rng, stepStr, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			n, err := strconv.Atoi(stepStr)
+			if err != nil || n <= 0 {
+				return f, fmt.Errorf("bad step in %q", part)
+			}
+			step = n
+		}
+
+		lo, hi := first, last
+		switch {
+		case rng == "*":
+			f.any = true
+		default:
+			a, b, isRange := strings.Cut(rng, "-")
+			var err error
+			if lo, err = cronValue(a, first, last, names); err != nil {
+				return f, err
+			}
+			hi = lo
+			if isRange {
+				if hi, err = cronValue(b, first, last, names); err != nil {
+					return f, err
+				}
+			} else if hasStep {
+				hi = last
+			}
+			if hi < lo {
+				return f, fmt.Errorf("bad range %q", rng)
+			}
+		}
+		for v := lo; v <= hi; v += step {
+			f.bits |= 1 << uint(v)
+		}
+	}
+	return f, nil
+}
+
+func cronValue(s string, first, last int, names map[string]int) (int, error) {
+	if v, ok := names[strings.ToLower(s)]; ok {
+		return v, nil
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil || v < first || v > last {
+		return 0, fmt.Errorf("value %q out of range %d-%d", s, first, last)
+	}
+	return v, nil
+}
+
+// matches reports whether the minute containing t is in the window, in loc
+// unless the expression carries its own zone.
+func (c *cronExpr) matches(t time.Time, loc *time.Location) bool {
+	if c.loc != nil {
+		loc = c.loc
+	}
+	t = t.In(loc)
+	if !c.minute.has(t.Minute()) || !c.hour.has(t.Hour()) || !c.month.has(int(t.Month())) {
+		return false
+	}
+	// cron's rule: with both day fields restricted, either may match
+	domOK, dowOK := c.dom.has(t.Day()), c.dow.has(int(t.Weekday()))
+	if c.dom.any || c.dow.any {
+		return domOK && dowOK
+	}
+	return domOK || dowOK
+}
@@ -0,0 +1,204 @@
+package maintenance
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// lookahead bounds how far NextOpen searches for an open minute.
+const lookahead = 366 * 24 * time.Hour
+
+// Spec is a schedule as written in an annotation or the environment.
+type Spec struct {
+	// Window holds cron expressions separated by ";"; updates are applied
+	// during the minutes they match. Empty means any time.
+	Window   string   `json:"window,omitempty"`
+	Timezone string   `json:"timezone,omitempty"` // IANA zone, default local time
+	Freeze   []string `json:"freeze,omitempty"`   // "2026-12-20/2027-01-06" or "2026-12-24T18:00/2026-12-26T09:00"
+}
+
+// IsZero reports a spec that sets nothing.
+func (s Spec) IsZero() bool {
+	return s.Window == "" && s.Timezone == "" && len(s.Freeze) == 0
+}
+
+// Over layers s on top of global: s's window and timezone replace the
+// global ones when set, and freezes from both apply.
+func (s Spec) Over(global Spec) Spec {
+	out := global
+	if s.Window != "" {
+		out.Window = s.Window
+	}
+	if s.Timezone != "" {
+		out.Timezone = s.Timezone
+	}
+	out.Freeze = append(append([]string(nil), global.Freeze...), s.Freeze...)
+	return out
+}
+
+// Load reads the global spec from MD_UPDATE_WINDOW, MD_TIMEZONE and
+// MD_FREEZE (comma separated).
+func Load() Spec {
+	var freeze []string
+	for _, f := range strings.Split(os.Getenv("MD_FREEZE"), ",") {
+		if f = strings.TrimSpace(f); f != "" {
+			freeze = append(freeze, f)
+		}
+	}
+	return Spec{
+		Window:   strings.TrimSpace(os.Getenv("MD_UPDATE_WINDOW")),
+		Timezone: strings.TrimSpace(os.Getenv("MD_TIMEZONE")),
+		Freeze:   freeze,
+	}
+}
+
+// period is a freeze, [start, end).
+type period struct {
+	start, end time.Time
+	src        string
+}
+
+// Schedule decides when updates may be applied. The zero value is always
+// open.
+type Schedule struct {
+	loc     *time.Location
+	windows []*cronExpr
+	freezes []period
+}
+
+// Compile parses s.
+func (s Spec) Compile() (*Schedule, error) {
+	sc := &Schedule{loc: time.Local}
+	if s.Timezone != "" {
+		loc, err := time.LoadLocation(s.Timezone)
+		if err != nil {
+			return nil, fmt.Errorf("timezone %q: %w", s.Timezone, err)
+		}
+		sc.loc = loc
+	}
+	for _, expr := range strings.Split(s.Window, ";") {
+		if strings.TrimSpace(expr) == "" {
+			continue
+		}
+		c, err := parseCron(expr)
+		if err != nil {
+			return nil, err
+		}
+		sc.windows = append(sc.windows, c)
+	}
+	for _, f := range s.Freeze {
+		p, err := parsePeriod(f, sc.loc)
+		if err != nil {
+			return nil, err
+		}
+		sc.freezes = append(sc.freezes, p)
+	}
+	return sc, nil
+}
+
+// parsePeriod reads "start/end", each a date (the end date is included) or
+// a local time "2006-01-02T15:04".
+func parsePeriod(s string, loc *time.Location) (period, error) {
+	a, b, ok := strings.Cut(strings.TrimSpace(s), "/")
+	if !ok {
+		return period{}, fmt.Errorf("freeze %q: want start/end", s)
+	}
+	start, _, err := parseBound(a, loc)
+	if err != nil {
+		return period{}, fmt.Errorf("freeze %q: %w", s, err)
+	}
+	end, dateOnly, err := parseBound(b, loc)
+	if err != nil {
+		return period{}, fmt.Errorf("freeze %q: %w", s, err)
+	}
+	if dateOnly {
+		end = end.AddDate(0, 0, 1)
+	}
+	if !end.After(start) {
+		return period{}, fmt.Errorf("freeze %q: ends before it starts", s)
+	}
+	return period{start: start, end: end, src: strings.TrimSpace(s)}, nil
+}
+
+func parseBound(s string, loc *time.Location) (time.Time, bool, error) {
+	s = strings.TrimSpace(s)
+	if t, err := time.ParseInLocation("2006-01-02T15:04", s, loc); err == nil {
+		return t, false, nil
+	}
+	t, err := time.ParseInLocation("2006-01-02", s, loc)
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("bad time %q, want 2006-01-02 or 2006-01-02T15:04", s)
+	}
+	return t, true, nil
+}
+
+// frozen returns the freeze covering t, if any.
+func (s *Schedule) frozen(t time.Time) (period, bool) {
+	for _, p := range s.freezes {
+		if !t.Before(p.start) && t.Before(p.end) {
+			return p, true
+		}
+	}
+	return period{}, false
+}
+
+func (s *Schedule) inWindow(t time.Time) bool {
+	if len(s.windows) == 0 {
+		return true
+	}
+	for _, c := range s.windows {
+		if c.matches(t, s.loc) {
+			return true
+		}
+	}
+	return false
+}
+
+// Open reports whether updates may be applied at t. When they may not, the
+// reason says why. A nil Schedule is always open.
+func (s *Schedule) Open(t time.Time) (bool, string) {
+	if s == nil {
+		return true, ""
+	}
+	if frozen, why := s.Frozen(t); frozen {
+		return false, why
+	}
+	if !s.inWindow(t) {
+		return false, "outside maintenance window"
+	}
+	return true, ""
+}
+
+// Frozen reports whether t falls in a freeze period, and which.
+func (s *Schedule) Frozen(t time.Time) (bool, string) {
+	if s == nil {
+		return false, ""
+	}
+	if p, ok := s.frozen(t); ok {
+		return true, fmt.Sprintf("frozen (%s)", p.src)
+	}
+	return false, ""
+}
+
+// NextOpen returns the first minute at or after t when updates may be
+// applied, or false when there is none within a year.
+func (s *Schedule) NextOpen(t time.Time) (time.Time, bool) {
+	if ok, _ := s.Open(t); ok {
+		return t, true
+	}
+	limit := t.Add(lookahead)
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	for t.Before(limit) {
+		if p, ok := s.frozen(t); ok {
+			t = p.end
+			continue
+		}
+		if s.inWindow(t) {
+			return t, true
+		}
+		t = t.Add(time.Minute)
+	}
+	return time.Time{}, false
+}
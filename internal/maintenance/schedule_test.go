@@ -0,0 +1,102 @@
+package maintenance
+
+import (
+	"testing"
+	"time"
+)
+
+func mustCompile(t *testing.T, s Spec) *Schedule {
+	t.Helper()
+	sc, err := s.Compile()
+	if err != nil {
+		t.Fatalf("compile %+v: %v", s, err)
+	}
+	return sc
+}
+
+func TestCron(t *testing.T) {
+	utc := time.UTC
+	for _, tc := range []struct {
+		expr string
+		at   string
+		want bool
+	}{
+		{"* 1-5 * * *", "2026-10-16T03:30", true},
+		{"* 1-5 * * *", "2026-10-16T06:00", false},
+		{"*/15 * * * *", "2026-10-16T06:45", true},
+		{"*/15 * * * *", "2026-10-16T06:46", false},
+		{"* 22-23 * * mon-fri", "2026-10-16T22:10", true}, // Friday
+		{"* 22-23 * * mon-fri", "2026-10-17T22:10", false},
+		{"* * * * 7", "2026-10-18T12:00", true}, // Sunday
+		{"* * * dec *", "2026-12-01T00:00", true},
+		{"* * 1 * sat", "2026-10-17T09:00", true}, // either day field matches
+		{"* * 1 * sat", "2026-10-16T09:00", false},
+		{"CRON_TZ=America/New_York * 2 * * *", "2026-10-16T06:30", true},
+	} {
+		c, err := parseCron(tc.expr)
+		if err != nil {
+			t.Fatalf("%q: %v", tc.expr, err)
+		}
+		at, _ := time.ParseInLocation("2006-01-02T15:04", tc.at, utc)
+		if got := c.matches(at, utc); got != tc.want {
+			t.Errorf("%q at %s = %v, want %v", tc.expr, tc.at, got, tc.want)
+		}
+	}
+
+	for _, bad := range []string{"* * * *", "60 * * * *", "* 5-1 * * *", "*/0 * * * *", "* * * foo *", "TZ=Nowhere/City * * * * *"} {
+		if _, err := parseCron(bad); err == nil {
+			t.Errorf("%q: want an error", bad)
+		}
+	}
+}
+
+func TestSchedule(t *testing.T) {
+	global := Spec{Window: "* 1-4 * * *", Timezone: "Europe/Stockholm", Freeze: []string{"2026-12-20/2027-01-06"}}
+	target := Spec{Window: "* 2 * * sat,sun", Freeze: []string{"2026-11-01T00:00/2026-11-02T12:00"}}
+	sc := mustCompile(t, target.Over(global))
+	loc, _ := time.LoadLocation("Europe/Stockholm")
+	at := func(s string) time.Time {
+		v, err := time.ParseInLocation("2006-01-02T15:04", s, loc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+
+	if ok, _ := sc.Open(at("2026-10-17T02:30")); !ok {
+		t.Fatal("Saturday 02:30 should be open")
+	}
+	if ok, why := sc.Open(at("2026-10-16T02:30")); ok || why != "outside maintenance window" {
+		t.Fatalf("Friday 02:30: %v %q", ok, why)
+	}
+	if ok, why := sc.Open(at("2026-12-26T02:30")); ok || why != "frozen (2026-12-20/2027-01-06)" {
+		t.Fatalf("Christmas: %v %q", ok, why)
+	}
+
+	if next, ok := sc.NextOpen(at("2026-10-16T15:07")); !ok || !next.Equal(at("2026-10-17T02:00")) {
+		t.Fatalf("next open = %s %v", next, ok)
+	}
+	// the freeze ends Nov 2 at noon, so the Sunday window is skipped
+	if next, ok := sc.NextOpen(at("2026-10-31T03:00")); !ok || !next.Equal(at("2026-11-07T02:00")) {
+		t.Fatalf("next open after freeze = %s %v", next, ok)
+	}
+	if next, _ := sc.NextOpen(at("2026-12-19T10:00")); !next.Equal(at("2027-01-09T02:00")) {
+		t.Fatalf("next open after the holiday freeze = %s", next)
+	}
+
+	var none *Schedule
+	if ok, _ := none.Open(time.Now()); !ok {
+		t.Fatal("a nil schedule is always open")
+	}
+
+	for _, bad := range []Spec{
+		{Timezone: "Mars/Olympus"},
+		{Freeze: []string{"2026-12-20"}},
+		{Freeze: []string{"2026-12-20/2026-12-01"}},
+		{Window: "* * *"},
+	} {
+		if _, err := bad.Compile(); err == nil {
+			t.Errorf("%+v: want an error", bad)
+		}
+	}
+}
@@ -0,0 +1,236 @@
+package policy
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Restrictions for the calver policy, relative to the current tag.
+const (
+	WithinYear  = "year"
+	WithinMonth = "month"
+)
+
+// calverPolicy follows calendar versions, see ResolveCalver.
+type calverPolicy struct{}
+
+func init() { Register(calverPolicy{}) }
+
+func (calverPolicy) Name() string    { return "calver" }
+func (calverPolicy) PicksTags() bool { return true }
+func (calverPolicy) Pin() Pin        { return PinTag }
+
+func (calverPolicy) Validate(opts Options) error {
+	_, err := parseCalverFormat(opts.Format)
+	return err
+}
+
+// calverOnly rejects the calver settings on another policy, which would
+// otherwise be ignored without a word.
+func calverOnly(policy string, opts Options) error {
+	switch {
+	case opts.Format != "":
+		return fmt.Errorf("format %q: only the calver policy uses it, not %s", opts.Format, policy)
+	case opts.Within != "":
+		return fmt.Errorf("within %q: only the calver policy uses it, not %s", opts.Within, policy)
+	}
+	return nil
+}
+
+func (calverPolicy) Select(tags []string, current string, opts Options, d *Decision) (string, error) {
+	return resolveCalver(tags, current, opts, d)
+}
+
+// Compare orders a and b component by component in opts.Format order.
+func (calverPolicy) Compare(a, b string, opts Options) (int, bool) {
+	cf, err := parseCalverFormat(opts.Format)
+	if err != nil {
+		return 0, false
+	}
+	va, okA := cf.parse(a)
+	vb, okB := cf.parse(b)
+	if !okA || !okB {
+		return 0, false
+	}
+	for i := range va {
+		if va[i] != vb[i] {
+			if va[i] < vb[i] {
+				return -1, true
+			}
+			return 1, true
+		}
+	}
+	return 0, true
+}
+
+// calverTokens maps the calver.org format tokens to the digits they accept.
+// Short years (YY, 0Y) count from 2000; MM/DD/WW are unpadded, 0M/0D/0W
+// zero-padded.
+var calverTokens = map[string]string{
+	"YYYY":  `\d{4}`,
+	"YY":    `[1-9]\d{0,2}|0`,
+	"0Y":    `\d{2,3}`,
+	"MM":    `1[0-2]|[1-9]`,
+	"0M":    `0[1-9]|1[0-2]`,
+	"WW":    `5[0-3]|[1-4]\d|[1-9]`,
+	"0W":    `0[1-9]|[1-4]\d|5[0-3]`,
+	"DD":    `3[01]|[12]\d|[1-9]`,
+	"0D":    `0[1-9]|[12]\d|3[01]`,
+	"MAJOR": `\d+`,
+	"MINOR": `\d+`,
+	"MICRO": `\d+`,
+}
+
+// calverKinds marks the tokens a Within restriction can pin.
+var calverKinds = map[string]string{
+	"YYYY": WithinYear, "YY": WithinYear, "0Y": WithinYear,
+	"MM": WithinMonth, "0M": WithinMonth,
+}
+
+// calverFormat is a parsed format such as "YYYY.MM.MICRO".
+type calverFormat struct {
+	re     *regexp.Regexp
+	tokens []string
+}
+
+func parseCalverFormat(format string) (*calverFormat, error) {
+	if format == "" {
+		return nil, fmt.Errorf("calver policy needs a format, e.g. YYYY.MM.MICRO")
+	}
+	cf := &calverFormat{}
+	var b strings.Builder
+	b.WriteString(`^v?`)
+	start := 0
+	for i := 0; i <= len(format); i++ {
+		if i < len(format) && !strings.ContainsRune(".-_", rune(format[i])) {
+			continue
+		}
+		tok := format[start:i]
+		tok = strings.ToUpper(tok)
+		pattern, ok := calverTokens[tok]
+		if !ok {
+			return nil, fmt.Errorf("format %q: unknown token %q", format, tok)
+		}
+		b.WriteString("(" + pattern + ")")
+		cf.tokens = append(cf.tokens, tok)
+		if i < len(format) {
+			b.WriteString(regexp.QuoteMeta(format[i : i+1]))
+		}
+		start = i + 1
+	}
+	b.WriteString("$")
+	cf.re = regexp.MustCompile(b.String())
+	return cf, nil
+}
+
+// parse returns the tag's values in format order, with short years widened.
+func (cf *calverFormat) parse(tag string) ([]int, bool) {
+	m := cf.re.FindStringSubmatch(tag)
+	if m == nil {
+		return nil, false
+	}
+	vals := make([]int, len(cf.tokens))
+	for i, tok := range cf.tokens {
+		n, err := strconv.Atoi(m[i+1])
+		if err != nil {
+			return nil, false
+		}
+		if tok == "YY" || tok == "0Y" {
+			n += 2000
+		}
+		vals[i] = n
+	}
+	return vals, true
+}
+
+// index returns the position of the year or month token, or -1.
+func (cf *calverFormat) index(kind string) int {
+	for i, tok := range cf.tokens {
+		if calverKinds[tok] == kind {
+			return i
+		}
+	}
+	return -1
+}
+
+// ResolveCalver picks the newest tag in opts.Format, comparing components in
+// format order. opts.Within "year" or "month" keeps to the year (and month)
+// of current, the calver counterpart of a semver range.
+func ResolveCalver(tags []string, current string, opts Options) (string, error) {
+	return resolveCalver(tags, current, opts, nil)
+}
+
+func resolveCalver(tags []string, current string, opts Options, d *Decision) (string, error) {
+	cf, err := parseCalverFormat(opts.Format)
+	if err != nil {
+		return "", err
+	}
+
+	var cur []int
+	var pinned []int // positions that must equal current's
+	if opts.Within != "" {
+		var ok bool
+		if cur, ok = cf.parse(strings.TrimSpace(current)); !ok {
+			return "", fmt.Errorf("current tag %q does not match format %q", current, opts.Format)
+		}
+		kinds := []string{WithinYear}
+		if opts.Within == WithinMonth {
+			kinds = append(kinds, WithinMonth)
+		}
+		for _, kind := range kinds {
+			i := cf.index(kind)
+			if i < 0 {
+				return "", fmt.Errorf("within %q: format %q has no %s", opts.Within, opts.Format, kind)
+			}
+			pinned = append(pinned, i)
+		}
+	}
+
+	type candidate struct {
+		tag  string
+		vals []int
+	}
+	var cands []candidate
+next:
+	for _, tag := range tags {
+		tag = strings.TrimSpace(tag)
+		vals, ok := cf.parse(tag)
+		if !ok {
+			d.reject(tag, "does not match format %q", opts.Format)
+			continue
+		}
+		for _, i := range pinned {
+			if vals[i] != cur[i] {
+				d.reject(tag, "not in the same %s as %q", opts.Within, current)
+				continue next
+			}
+		}
+		cands = append(cands, candidate{tag, vals})
+	}
+	if len(cands) == 0 {
+		if opts.Within != "" {
+			return "", fmt.Errorf("no tags match calver format %q within the same %s as %q", opts.Format, opts.Within, current)
+		}
+		return "", fmt.Errorf("no tags match calver format %q", opts.Format)
+	}
+	sort.SliceStable(cands, func(i, j int) bool {
+		a, b := cands[i], cands[j]
+		for k := range a.vals {
+			if a.vals[k] != b.vals[k] {
+				return a.vals[k] < b.vals[k]
+			}
+		}
+		return a.tag < b.tag
+	})
+	if d != nil {
+		best := make([]string, len(cands))
+		for i, c := range cands {
+			best[len(cands)-1-i] = c.tag
+		}
+		d.rank(best)
+	}
+	return cands[len(cands)-1].tag, nil
+}
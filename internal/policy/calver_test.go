@@ -0,0 +1,61 @@
+package policy
+
+import "testing"
+
+func TestResolveCalver_HomeAssistant(t *testing.T) {
+	// semver would sort these by string length / reject the padding
+	tags := []string{"2024.9.3", "2024.10.0", "2024.10.3", "2024.10.0b1", "2023.12.4", "stable", "latest"}
+	got, err := ResolveCalver(tags, "2024.9.3", Options{Format: "YYYY.MM.MICRO"})
+	if err != nil {
+		t.Fatalf("ResolveCalver error: %v", err)
+	}
+	if got != "2024.10.3" {
+		t.Fatalf("want 2024.10.3, got %q", got)
+	}
+}
+
+func TestResolveCalver_ShortYearPaddedMonth(t *testing.T) {
+	tags := []string{"22.04", "24.04", "24.10", "23.10", "24.4"}
+	got, err := ResolveCalver(tags, "24.04", Options{Format: "YY.0M"})
+	if err != nil || got != "24.10" {
+		t.Fatalf("want 24.10, got %q, %v", got, err)
+	}
+}
+
+func TestResolveCalver_Within(t *testing.T) {
+	tags := []string{"2024.11.1", "2024.12.0", "2024.12.2", "2025.1.0"}
+	opts := Options{Format: "YYYY.MM.MICRO", Within: WithinYear}
+	if got, err := ResolveCalver(tags, "2024.11.1", opts); err != nil || got != "2024.12.2" {
+		t.Fatalf("same year: want 2024.12.2, got %q, %v", got, err)
+	}
+	opts.Within = WithinMonth
+	if got, err := ResolveCalver(tags, "2024.11.0", opts); err != nil || got != "2024.11.1" {
+		t.Fatalf("same month: want 2024.11.1, got %q, %v", got, err)
+	}
+	if _, err := ResolveCalver(tags, "main", opts); err == nil {
+		t.Fatalf("expected error when the current tag is not calver")
+	}
+}
+
+func TestResolveCalver_Days(t *testing.T) {
+	tags := []string{"2024.05.09", "2024.05.10", "2024.04.30"}
+	if got, err := ResolveCalver(tags, "", Options{Format: "YYYY.0M.0D"}); err != nil || got != "2024.05.10" {
+		t.Fatalf("want 2024.05.10, got %q, %v", got, err)
+	}
+}
+
+func TestCalverOptionsValidate(t *testing.T) {
+	for _, bad := range []Options{
+		{Format: "YYYY.QQ"},
+		{Format: "MAJOR.MINOR", Within: WithinYear},
+		{Format: "YYYY.MICRO", Within: WithinMonth},
+		{Format: "YYYY.MM", Within: "week"},
+	} {
+		if err := bad.Validate(); err == nil {
+			t.Fatalf("expected error for %+v", bad)
+		}
+	}
+	if err := (Options{Format: "yy.0m", Within: WithinMonth}).Validate(); err != nil {
+		t.Fatalf("valid format rejected: %v", err)
+	}
+}
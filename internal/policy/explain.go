@@ -0,0 +1,70 @@
+package policy
+
+import "fmt"
+
+// explainLimit caps the tags a Decision lists, so repositories with
+// thousands of tags do not bloat the state file.
+const explainLimit = 50
+
+// reasonSkipped is the Rejection reason for tags removed by opts.Skip.
+const reasonSkipped = "skip rule"
+
+// Decision explains one tag-picking evaluation: the tags that stayed
+// candidates, the ones filtered out and why, and the one chosen.
+type Decision struct {
+	Policy     string      `json:"policy"`
+	Current    string      `json:"current,omitempty"`
+	Candidates []string    `json:"candidates,omitempty"` // most preferred first
+	Rejected   []Rejection `json:"rejected,omitempty"`
+	Omitted    int         `json:"omitted,omitempty"` // tags left out past explainLimit
+	Skips      []string    `json:"skipped,omitempty"` // every tag skip rules removed, never truncated
+	Chosen     string      `json:"chosen,omitempty"`
+	Error      string      `json:"error,omitempty"`
+}
+
+// Rejection is a tag the policy did not consider, with the reason.
+type Rejection struct {
+	Tag    string `json:"tag"`
+	Reason string `json:"reason"`
+}
+
+// Skipped lists the tags skip rules removed.
+func (d *Decision) Skipped() []string {
+	if d == nil {
+		return nil
+	}
+	return d.Skips
+}
+
+// skip records a tag removed by a skip rule, then rejects it; d may be nil.
+func (d *Decision) skip(tag string) {
+	if d == nil {
+		return
+	}
+	d.Skips = append(d.Skips, tag)
+	d.reject(tag, reasonSkipped)
+}
+
+// reject records why tag was not considered; d may be nil.
+func (d *Decision) reject(tag, format string, args ...any) {
+	if d == nil {
+		return
+	}
+	if len(d.Rejected) == explainLimit {
+		d.Omitted++
+		return
+	}
+	d.Rejected = append(d.Rejected, Rejection{Tag: tag, Reason: fmt.Sprintf(format, args...)})
+}
+
+// rank records the candidates, most preferred first; d may be nil.
+func (d *Decision) rank(best []string) {
+	if d == nil {
+		return
+	}
+	if len(best) > explainLimit {
+		d.Omitted += len(best) - explainLimit
+		best = best[:explainLimit]
+	}
+	d.Candidates = append([]string(nil), best...)
+}
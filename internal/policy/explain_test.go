@@ -0,0 +1,49 @@
+package policy
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestResolve_ExplainsDecision(t *testing.T) {
+	tags := []string{"1.2.0", "1.3.0", "1.4.0-rc.1", "2.0.0", "1.3.1", "main"}
+	d, err := Resolve("semver", tags, "1.2.0", Options{Update: UpdateMinor, Skip: []string{"1.3.1"}})
+	if err != nil {
+		t.Fatalf("Resolve error: %v", err)
+	}
+	if d.Chosen != "1.3.0" || strings.Join(d.Candidates, ",") != "1.3.0,1.2.0" {
+		t.Fatalf("chosen %q from %v", d.Chosen, d.Candidates)
+	}
+	want := map[string]string{
+		"1.3.1":      "skip rule",
+		"1.4.0-rc.1": "pre-release",
+		"2.0.0":      "beyond minor updates",
+		"main":       "not semver",
+	}
+	if len(d.Rejected) != len(want) {
+		t.Fatalf("rejected: %+v", d.Rejected)
+	}
+	for _, r := range d.Rejected {
+		if want[r.Tag] != r.Reason {
+			t.Errorf("%s: reason %q, want %q", r.Tag, r.Reason, want[r.Tag])
+		}
+	}
+}
+
+func TestResolve_ExplainsFailureAndCapsLists(t *testing.T) {
+	var tags []string
+	for i := 0; i < explainLimit+10; i++ {
+		tags = append(tags, fmt.Sprintf("sha-%d", i))
+	}
+	d, err := Resolve("regex", tags, "", Options{Filter: `^v(?P<v>.+)$`})
+	if err == nil {
+		t.Fatalf("expected an error when nothing matches")
+	}
+	if d.Error == "" || d.Chosen != "" {
+		t.Fatalf("failed decision: %+v", d)
+	}
+	if len(d.Rejected) != explainLimit || d.Omitted != 10 || d.Rejected[0].Reason != "does not match filter" {
+		t.Fatalf("rejected %d, omitted %d", len(d.Rejected), d.Omitted)
+	}
+}
@@ -0,0 +1,213 @@
+package policy
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/Masterminds/semver/v3"
+)
+
+// Orders for the value extracted by a filter.
+const (
+	OrderSemver       = "semver"
+	OrderNumeric      = "numeric"
+	OrderAlphabetical = "alphabetical"
+)
+
+// regexPolicy picks by a filter regex, see ResolveFilter.
+type regexPolicy struct{}
+
+func init() { Register(regexPolicy{}) }
+
+func (regexPolicy) Name() string    { return "regex" }
+func (regexPolicy) PicksTags() bool { return true }
+func (regexPolicy) Pin() Pin        { return PinTag }
+
+func (p regexPolicy) Validate(opts Options) error {
+	if opts.Filter == "" {
+		return fmt.Errorf("regex policy needs a filter")
+	}
+	return calverOnly(p.Name(), opts)
+}
+
+func (regexPolicy) Select(tags []string, _ string, opts Options, d *Decision) (string, error) {
+	return resolveFilter(tags, opts, d)
+}
+
+func (regexPolicy) Compare(a, b string, opts Options) (int, bool) {
+	return compareFiltered(a, b, opts)
+}
+
+// compareFiltered orders the values opts.Filter extracts from a and b, in
+// opts.Order and reversed for opts.Sort "desc".
+func compareFiltered(a, b string, opts Options) (int, bool) {
+	ex, err := newExtractor(opts)
+	if err != nil {
+		return 0, false
+	}
+	va, okA := ex.value(a)
+	vb, okB := ex.value(b)
+	if !okA || !okB {
+		return 0, false
+	}
+	cmp, ok := ex.compare(va, vb)
+	if opts.Sort == SortDesc {
+		cmp = -cmp
+	}
+	return cmp, ok
+}
+
+// ResolveFilter picks a tag for the "regex" policy, modelled on Flux's
+// ImagePolicy filterTags: tags not matching opts.Filter are dropped, the first
+// named capture group (or the whole match if there is none) is extracted, and
+// the tag with the highest value in opts.Order wins (the lowest for opts.Sort
+// "desc"). With the semver order, Range and Prerelease apply to the extracted
+// value. Ties go to the tag that sorts last, so the result does not depend on
+// the registry's tag order.
+func ResolveFilter(tags []string, opts Options) (string, error) {
+	return resolveFilter(tags, opts, nil)
+}
+
+func resolveFilter(tags []string, opts Options, d *Decision) (string, error) {
+	ex, err := newExtractor(opts)
+	if err != nil {
+		return "", err
+	}
+	constraint, err := opts.constraint()
+	if err != nil {
+		return "", err
+	}
+
+	type candidate struct{ tag, val string }
+	var cands []candidate
+	matched := 0
+	for _, tag := range tags {
+		tag = strings.TrimSpace(tag)
+		val, ok := ex.value(tag)
+		if !ok {
+			d.reject(tag, "does not match filter")
+			continue
+		}
+		matched++
+		if ex.order == OrderSemver {
+			v, err := semver.NewVersion(val)
+			if err != nil {
+				d.reject(tag, "%q is not semver", val)
+				continue
+			}
+			if !opts.allowsPrerelease(v.Prerelease()) {
+				d.reject(tag, "pre-release")
+				continue
+			}
+			if constraint != nil && !constraint.Check(v) {
+				d.reject(tag, "outside range %q", opts.Range)
+				continue
+			}
+		} else if _, ok := ex.compare(val, val); !ok {
+			d.reject(tag, "%q is not %s", val, ex.order)
+			continue
+		}
+		cands = append(cands, candidate{tag, val})
+	}
+	if matched == 0 {
+		return "", fmt.Errorf("no tags match filter %q", opts.Filter)
+	}
+	if len(cands) == 0 {
+		return "", fmt.Errorf("no tags have a valid %s value", ex.order)
+	}
+
+	sort.SliceStable(cands, func(i, j int) bool {
+		cmp, _ := ex.compare(cands[i].val, cands[j].val)
+		if cmp == 0 {
+			return cands[i].tag < cands[j].tag
+		}
+		return cmp < 0
+	})
+	if opts.Sort == SortDesc {
+		slices.Reverse(cands)
+	}
+	if d != nil {
+		best := make([]string, len(cands))
+		for i, c := range cands {
+			best[len(cands)-1-i] = c.tag
+		}
+		d.rank(best)
+	}
+	return cands[len(cands)-1].tag, nil
+}
+
+// extractor pulls the ordered value out of a tag for the filter-based
+// policies.
+type extractor struct {
+	re    *regexp.Regexp
+	group int
+	order string
+}
+
+func newExtractor(opts Options) (*extractor, error) {
+	if opts.Filter == "" {
+		return nil, fmt.Errorf("regex policy needs a filter")
+	}
+	re, err := regexp.Compile(opts.Filter)
+	if err != nil {
+		return nil, fmt.Errorf("filter %q: %w", opts.Filter, err)
+	}
+	ex := &extractor{re: re, order: opts.Order}
+	switch ex.order {
+	case "":
+		ex.order = OrderSemver
+	case OrderSemver, OrderNumeric, OrderAlphabetical:
+	default:
+		return nil, fmt.Errorf("unknown order %q", ex.order)
+	}
+	for i, name := range re.SubexpNames() {
+		if name != "" {
+			ex.group = i
+			break
+		}
+	}
+	return ex, nil
+}
+
+// value returns the extracted value of tag, if the filter matches.
+func (ex *extractor) value(tag string) (string, bool) {
+	m := ex.re.FindStringSubmatch(tag)
+	if m == nil || m[ex.group] == "" {
+		return "", false
+	}
+	return m[ex.group], true
+}
+
+// compare orders two extracted values; ok is false if either is not valid
+// in the extractor's order.
+func (ex *extractor) compare(a, b string) (cmp int, ok bool) {
+	switch ex.order {
+	case OrderSemver:
+		va, errA := semver.NewVersion(a)
+		vb, errB := semver.NewVersion(b)
+		if errA != nil || errB != nil {
+			return 0, false
+		}
+		return va.Compare(vb), true
+	case OrderNumeric:
+		na, errA := strconv.ParseFloat(a, 64)
+		nb, errB := strconv.ParseFloat(b, 64)
+		if errA != nil || errB != nil {
+			return 0, false
+		}
+		switch {
+		case na < nb:
+			return -1, true
+		case na > nb:
+			return 1, true
+		}
+		return 0, true
+	case OrderAlphabetical:
+		return strings.Compare(a, b), true
+	}
+	return 0, false
+}
@@ -0,0 +1,74 @@
+package policy
+
+import "testing"
+
+func TestResolveFilter_Alphabetical(t *testing.T) {
+	tags := []string{
+		"RELEASE.2024-04-18T19-09-19Z",
+		"RELEASE.2024-05-01T12-00-00Z",
+		"RELEASE.2024-04-29T07-12-00Z",
+		"latest",
+	}
+	got, err := ResolveFilter(tags, Options{Filter: `^RELEASE\.(?P<ts>.+Z)$`, Order: OrderAlphabetical})
+	if err != nil {
+		t.Fatalf("ResolveFilter error: %v", err)
+	}
+	if got != "RELEASE.2024-05-01T12-00-00Z" {
+		t.Fatalf("want newest RELEASE tag, got %q", got)
+	}
+}
+
+func TestResolveFilter_Numeric(t *testing.T) {
+	// numeric order must not be fooled by string length
+	tags := []string{"main-3f2a1c-1715000000", "main-9e8d7c-999999999", "main-aa11bb-1716000000", "pr-12-1800000000"}
+	got, err := ResolveFilter(tags, Options{Filter: `^main-[a-f0-9]+-(?P<ts>\d+)$`, Order: OrderNumeric})
+	if err != nil {
+		t.Fatalf("ResolveFilter error: %v", err)
+	}
+	if got != "main-aa11bb-1716000000" {
+		t.Fatalf("want the newest main build, got %q", got)
+	}
+}
+
+func TestResolveFilter_SemverExtract(t *testing.T) {
+	tags := []string{"v2.3.1-ls187", "v2.10.0-ls190", "v2.9.4-ls189", "nightly"}
+	opts := Options{Filter: `^v(?P<version>\d+\.\d+\.\d+)-ls\d+$`}
+	got, err := ResolveFilter(tags, opts)
+	if err != nil {
+		t.Fatalf("ResolveFilter error: %v", err)
+	}
+	if got != "v2.10.0-ls190" {
+		t.Fatalf("want v2.10.0-ls190 (semver, not string order), got %q", got)
+	}
+
+	opts.Range = "<2.10.0"
+	if got, err = ResolveFilter(tags, opts); err != nil || got != "v2.9.4-ls189" {
+		t.Fatalf("range should apply to the extracted version, got %q, %v", got, err)
+	}
+}
+
+func TestResolveFilter_TieGoesToLastTag(t *testing.T) {
+	tags := []string{"1.2.3-ls12", "1.2.3-ls11"}
+	got, err := ResolveFilter(tags, Options{Filter: `^(?P<v>\d+\.\d+\.\d+)-ls\d+$`})
+	if err != nil || got != "1.2.3-ls12" {
+		t.Fatalf("want 1.2.3-ls12, got %q, %v", got, err)
+	}
+}
+
+func TestResolveFilter_Errors(t *testing.T) {
+	if _, err := ResolveFilter([]string{"a"}, Options{}); err == nil {
+		t.Fatalf("expected error without a filter")
+	}
+	if _, err := ResolveFilter([]string{"a", "b"}, Options{Filter: `^\d+$`}); err == nil {
+		t.Fatalf("expected error when nothing matches")
+	}
+	if _, err := ResolveFilter([]string{"a", "b"}, Options{Filter: `^(?P<n>.)$`, Order: OrderNumeric}); err == nil {
+		t.Fatalf("expected error when no value is numeric")
+	}
+	if err := (Options{Filter: `(`}).Validate(); err == nil {
+		t.Fatalf("expected error for a bad filter")
+	}
+	if err := (Options{Order: "newest"}).Validate(); err == nil {
+		t.Fatalf("expected error for an unknown order")
+	}
+}
@@ -0,0 +1,182 @@
+package policy
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/Masterminds/semver/v3"
+)
+
+// Options are the per-image tag selection settings carried by the magos
+// annotation. The zero value keeps each policy's plain behaviour.
+type Options struct {
+	// Range limits semver candidates to a constraint such as ">=16.0.0 <17.0.0"
+	// or "~16" (Masterminds syntax).
+	Range string `json:"range,omitempty"`
+	// Prerelease opts into pre-release tags, which are skipped by default:
+	// "*" accepts any pre-release, a channel name such as "rc" or "beta" only
+	// pre-releases of that channel (1.2.0-rc.1, 1.2.0-rc2).
+	Prerelease string `json:"prerelease,omitempty"`
+	// Filter is the regex of the "regex" policy. Its first named capture
+	// group (or the whole match) is the value tags are ordered by.
+	Filter string `json:"filter,omitempty"`
+	// Order compares extracted values: semver (default), numeric or
+	// alphabetical.
+	Order string `json:"order,omitempty"`
+	// Sort is the direction for numerical, alphabetical and regex policies:
+	// "asc" (default) follows the highest value, "desc" the lowest.
+	Sort string `json:"sort,omitempty"`
+	// Format is the calver layout, built from calver.org tokens (YYYY, YY,
+	// 0Y, MM, 0M, WW, 0W, DD, 0D, MAJOR, MINOR, MICRO) joined by ".", "-"
+	// or "_", e.g. "YYYY.MM.MICRO" or "YY.0M".
+	Format string `json:"format,omitempty"`
+	// Within restricts calver candidates to the current tag's "year" or
+	// "month".
+	Within string `json:"within,omitempty"`
+	// Update limits semver moves relative to the current tag: "patch",
+	// "minor" or "major" (the default).
+	Update string `json:"update,omitempty"`
+	// Variant keeps semver to tags with this suffix ("alpine" for
+	// 1.25.3-alpine). Unset, the suffix of the current tag is used;
+	// "none" keeps to plain versions.
+	Variant string `json:"variant,omitempty"`
+	// Skip removes known-broken releases from selection: exact tags
+	// ("1.25.4") or semver constraints (">=2.0.0 <2.0.3").
+	Skip []string `json:"skip,omitempty"`
+	// AllowDowngrade lets a tag-picking policy move to a version below the
+	// one deployed, e.g. after a release was pulled upstream.
+	AllowDowngrade bool `json:"allowDowngrade,omitempty"`
+}
+
+// Update levels for semver, relative to the current tag.
+const (
+	UpdatePatch = "patch"
+	UpdateMinor = "minor"
+	UpdateMajor = "major"
+)
+
+// channelPattern is a single pre-release identifier as allowed by semver.
+var channelPattern = regexp.MustCompile(`^[0-9A-Za-z-]+$`)
+
+// Validate rejects settings that can never be evaluated, so a bad annotation
+// is reported once when it is parsed rather than on every poll.
+func (o Options) Validate() error {
+	if _, err := o.constraint(); err != nil {
+		return err
+	}
+	if o.Filter != "" {
+		if _, err := regexp.Compile(o.Filter); err != nil {
+			return fmt.Errorf("filter %q: %w", o.Filter, err)
+		}
+	}
+	switch o.Order {
+	case "", OrderSemver, OrderNumeric, OrderAlphabetical:
+	default:
+		return fmt.Errorf("order %q: want semver, numeric or alphabetical", o.Order)
+	}
+	switch o.Sort {
+	case "", SortAsc, SortDesc:
+	default:
+		return fmt.Errorf("sort %q: want asc or desc", o.Sort)
+	}
+	if o.Format != "" {
+		cf, err := parseCalverFormat(o.Format)
+		if err != nil {
+			return err
+		}
+		if o.Within != "" && cf.index(WithinYear) < 0 {
+			return fmt.Errorf("within %q: format %q has no year", o.Within, o.Format)
+		}
+		if o.Within == WithinMonth && cf.index(WithinMonth) < 0 {
+			return fmt.Errorf("within %q: format %q has no month", o.Within, o.Format)
+		}
+	}
+	switch o.Within {
+	case "", WithinYear, WithinMonth:
+	default:
+		return fmt.Errorf("within %q: want year or month", o.Within)
+	}
+	switch o.Update {
+	case "", UpdatePatch, UpdateMinor, UpdateMajor:
+	default:
+		return fmt.Errorf("update %q: want patch, minor or major", o.Update)
+	}
+	if _, err := o.skipRules(); err != nil {
+		return err
+	}
+	if o.Variant != "" && !variantPattern.MatchString(o.Variant) {
+		return fmt.Errorf("variant %q: want a tag suffix like \"alpine\"", o.Variant)
+	}
+	if o.Prerelease != "" && o.Prerelease != "*" && !channelPattern.MatchString(o.Prerelease) {
+		return fmt.Errorf("prerelease %q: want \"*\" or a channel name like \"rc\"", o.Prerelease)
+	}
+	return nil
+}
+
+// String is a compact, stable form of the settings that change which tags
+// are candidates, used in logs and to keep differently configured watchers
+// of one image apart. Skip is left out: skipping a release does not start a
+// new channel.
+func (o Options) String() string {
+	var parts []string
+	if o.Range != "" {
+		parts = append(parts, "range="+o.Range)
+	}
+	if o.Prerelease != "" {
+		parts = append(parts, "prerelease="+o.Prerelease)
+	}
+	if o.Filter != "" {
+		parts = append(parts, "filter="+o.Filter)
+	}
+	if o.Order != "" {
+		parts = append(parts, "order="+o.Order)
+	}
+	if o.Sort != "" {
+		parts = append(parts, "sort="+o.Sort)
+	}
+	if o.Format != "" {
+		parts = append(parts, "format="+o.Format)
+	}
+	if o.Within != "" {
+		parts = append(parts, "within="+o.Within)
+	}
+	if o.Update != "" {
+		parts = append(parts, "update="+o.Update)
+	}
+	if o.Variant != "" {
+		parts = append(parts, "variant="+strings.ToLower(o.Variant))
+	}
+	return strings.Join(parts, ",")
+}
+
+// constraint compiles Range; nil means no range was given.
+func (o Options) constraint() (*semver.Constraints, error) {
+	if o.Range == "" {
+		return nil, nil
+	}
+	c, err := semver.NewConstraint(o.Range)
+	if err != nil {
+		return nil, fmt.Errorf("range %q: %w", o.Range, err)
+	}
+	// without this, Masterminds drops every pre-release unless the range
+	// itself names one
+	c.IncludePrerelease = o.Prerelease != ""
+	return c, nil
+}
+
+// allowsPrerelease reports whether a version with pre-release part pre (""
+// for a stable release) may be picked.
+func (o Options) allowsPrerelease(pre string) bool {
+	switch {
+	case pre == "":
+		return true
+	case o.Prerelease == "":
+		return false
+	case o.Prerelease == "*":
+		return true
+	}
+	channel, _, _ := strings.Cut(pre, ".")
+	channel = strings.TrimRight(channel, "0123456789")
+	return strings.EqualFold(channel, o.Prerelease)
+}
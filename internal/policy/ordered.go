@@ -0,0 +1,105 @@
+package policy
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Sort directions for the numerical and alphabetical policies, with Flux's
+// meaning: "asc" follows the highest value, "desc" the lowest.
+const (
+	SortAsc  = "asc"
+	SortDesc = "desc"
+)
+
+var digitRun = regexp.MustCompile(`\d+`)
+
+// orderedPolicy is the numerical or alphabetical policy: a filter (by
+// default the shape of the current tag) with a fixed order.
+type orderedPolicy struct {
+	name  string
+	order string
+}
+
+func init() {
+	Register(orderedPolicy{name: "numerical", order: OrderNumeric})
+	Register(orderedPolicy{name: "alphabetical", order: OrderAlphabetical})
+}
+
+func (p orderedPolicy) Name() string  { return p.name }
+func (orderedPolicy) PicksTags() bool { return true }
+func (orderedPolicy) Pin() Pin        { return PinTag }
+
+func (p orderedPolicy) Validate(opts Options) error {
+	return calverOnly(p.name, opts)
+}
+
+func (p orderedPolicy) Select(tags []string, current string, opts Options, d *Decision) (string, error) {
+	return resolveOrdered(tags, current, opts, p.order, d)
+}
+
+func (p orderedPolicy) Compare(a, b string, opts Options) (int, bool) {
+	if opts.Filter == "" {
+		f, err := shapeFilter(b, p.order == OrderNumeric)
+		if err != nil {
+			return 0, false
+		}
+		opts.Filter = f
+	}
+	opts.Order = p.order
+	return compareFiltered(a, b, opts)
+}
+
+// ResolveNumerical picks the tag with the highest number (lowest for
+// opts.Sort "desc"). Without opts.Filter, only tags shaped like current are
+// considered and its last run of digits is the number, so build-1042 follows
+// build-1043 but ignores pr-7-1050.
+func ResolveNumerical(tags []string, current string, opts Options) (string, error) {
+	return resolveOrdered(tags, current, opts, OrderNumeric, nil)
+}
+
+// ResolveAlphabetical picks the tag that sorts last (first for opts.Sort
+// "desc"), which suits timestamp tags such as 2024-05-01T12-00-00Z. Without
+// opts.Filter, only tags shaped like current are considered.
+func ResolveAlphabetical(tags []string, current string, opts Options) (string, error) {
+	return resolveOrdered(tags, current, opts, OrderAlphabetical, nil)
+}
+
+func resolveOrdered(tags []string, current string, opts Options, order string, d *Decision) (string, error) {
+	if opts.Filter == "" {
+		f, err := shapeFilter(current, order == OrderNumeric)
+		if err != nil {
+			return "", err
+		}
+		opts.Filter = f
+	}
+	opts.Order = order
+	return resolveFilter(tags, opts, d)
+}
+
+// shapeFilter turns a tag into a regex matching tags of the same shape: the
+// literal text is kept (case-insensitively, as the watcher lowercases refs)
+// and digit runs match any digits. For numeric ordering the last digit run
+// becomes the extracted value.
+func shapeFilter(tag string, numeric bool) (string, error) {
+	runs := digitRun.FindAllStringIndex(tag, -1)
+	if numeric && len(runs) == 0 {
+		return "", fmt.Errorf("tag %q has no number to order by; set a filter", tag)
+	}
+	var b strings.Builder
+	b.WriteString("(?i)^")
+	last := 0
+	for i, r := range runs {
+		b.WriteString(regexp.QuoteMeta(tag[last:r[0]]))
+		if numeric && i == len(runs)-1 {
+			b.WriteString(`(?P<n>\d+)`)
+		} else {
+			b.WriteString(`\d+`)
+		}
+		last = r[1]
+	}
+	b.WriteString(regexp.QuoteMeta(tag[last:]))
+	b.WriteString("$")
+	return b.String(), nil
+}
@@ -0,0 +1,61 @@
+package policy
+
+import "testing"
+
+func TestResolveNumerical_FollowsBuildNumbers(t *testing.T) {
+	tags := []string{"build-998", "build-1042", "build-1043", "pr-7-1050", "latest"}
+	got, err := ResolveNumerical(tags, "build-1042", Options{})
+	if err != nil {
+		t.Fatalf("ResolveNumerical error: %v", err)
+	}
+	if got != "build-1043" {
+		t.Fatalf("want build-1043, got %q", got)
+	}
+
+	got, err = ResolveNumerical(tags, "build-1042", Options{Sort: SortDesc})
+	if err != nil || got != "build-998" {
+		t.Fatalf("desc: want build-998, got %q, %v", got, err)
+	}
+}
+
+func TestResolveNumerical_BareNumbersAndFilter(t *testing.T) {
+	tags := []string{"9", "10", "11-rc", "main"}
+	if got, err := ResolveNumerical(tags, "9", Options{}); err != nil || got != "10" {
+		t.Fatalf("want 10, got %q, %v", got, err)
+	}
+	if _, err := ResolveNumerical(tags, "main", Options{}); err == nil {
+		t.Fatalf("expected error for a current tag without a number")
+	}
+	got, err := ResolveNumerical(tags, "main", Options{Filter: `^(?P<n>\d+)(-rc)?$`})
+	if err != nil || got != "11-rc" {
+		t.Fatalf("filter: want 11-rc, got %q, %v", got, err)
+	}
+}
+
+func TestResolveAlphabetical_Timestamps(t *testing.T) {
+	tags := []string{"2024-04-30T23-59-59Z", "2024-05-01T12-00-00Z", "2024-05-01T09-30-00Z", "latest", "stable"}
+	got, err := ResolveAlphabetical(tags, "2024-04-30T23-59-59Z", Options{})
+	if err != nil {
+		t.Fatalf("ResolveAlphabetical error: %v", err)
+	}
+	if got != "2024-05-01T12-00-00Z" {
+		t.Fatalf("want the newest timestamp (not %q)", got)
+	}
+	got, err = ResolveAlphabetical(tags, "2024-04-30t23-59-59z", Options{Sort: SortDesc})
+	if err != nil || got != "2024-04-30T23-59-59Z" {
+		t.Fatalf("desc: want the oldest timestamp, got %q, %v", got, err)
+	}
+}
+
+func TestShapeFilter(t *testing.T) {
+	f, err := shapeFilter("v1.2-b7", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `(?i)^v\d+\.\d+-b(?P<n>\d+)$`; f != want {
+		t.Fatalf("got %s, want %s", f, want)
+	}
+	if err := (Options{Sort: "up"}).Validate(); err == nil {
+		t.Fatalf("expected error for an unknown sort")
+	}
+}
@@ -0,0 +1,107 @@
+package policy
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Policy is one value of an annotation's "policy": how the image to deploy
+// is chosen and how an update is written into the compose file.
+type Policy interface {
+	// Name is the annotation value, in lower case.
+	Name() string
+	// PicksTags reports policies that choose among the repository's tags
+	// rather than following the annotated one.
+	PicksTags() bool
+	// Select returns the tag to deploy. Tag-picking policies choose from
+	// tags, relative to current, the tag deployed now, and record in d
+	// (which may be nil) why tags were passed over; the others return
+	// current.
+	Select(tags []string, current string, opts Options, d *Decision) (string, error)
+	// Pin says how an update is written into the compose file.
+	Pin() Pin
+	// Validate rejects options the policy cannot work with, beyond what
+	// Options.Validate checks for every policy.
+	Validate(opts Options) error
+}
+
+// Pin is how a policy writes an update into the compose file.
+type Pin int
+
+const (
+	PinNone   Pin = iota // never rewritten, and so never watched
+	PinTag               // image:tag
+	PinDigest            // image@sha256:...
+)
+
+// Comparer is implemented by policies that can order two tags; it powers
+// the downgrade guard. ok is false when either tag cannot be ordered, and a
+// negative result means the policy prefers b.
+type Comparer interface {
+	Compare(a, b string, opts Options) (cmp int, ok bool)
+}
+
+// Channeler is implemented by policies that split one image into channels
+// tracked apart, beyond what Options.String already distinguishes.
+type Channeler interface {
+	// Channel is appended to the state key of a target on current.
+	Channel(current string, opts Options) string
+}
+
+var policies = make(map[string]Policy)
+
+// Register makes p available under its name. It is meant to be called from
+// init and panics when the name is taken.
+func Register(p Policy) {
+	name := strings.ToLower(p.Name())
+	if _, dup := policies[name]; dup {
+		panic(fmt.Sprintf("policy: Register called twice for %q", name))
+	}
+	policies[name] = p
+}
+
+// Lookup returns the policy registered under name, ignoring case.
+func Lookup(name string) (Policy, error) {
+	p, ok := policies[strings.ToLower(strings.TrimSpace(name))]
+	if !ok {
+		return nil, fmt.Errorf("unknown policy %q (want one of %s)", name, strings.Join(Names(), ", "))
+	}
+	return p, nil
+}
+
+// Names lists the registered policies in alphabetical order.
+func Names() []string {
+	names := make([]string, 0, len(policies))
+	for name := range policies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// followPolicy tracks the annotated tag itself.
+type followPolicy struct {
+	name string
+	pin  Pin
+}
+
+func (p followPolicy) Name() string  { return p.name }
+func (followPolicy) PicksTags() bool { return false }
+func (p followPolicy) Pin() Pin      { return p.pin }
+
+func (p followPolicy) Validate(opts Options) error {
+	return calverOnly(p.name, opts)
+}
+
+func (p followPolicy) Select(_ []string, current string, _ Options, _ *Decision) (string, error) {
+	return current, nil
+}
+
+func init() {
+	// "latest" follows the annotated tag and rewrites it as a tag, "digest"
+	// pins what it points at, and "manual" leaves the line alone.
+	Register(followPolicy{name: "latest", pin: PinTag})
+	Register(followPolicy{name: "digest", pin: PinDigest})
+	Register(followPolicy{name: "manual", pin: PinNone})
+}
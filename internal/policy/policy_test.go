@@ -0,0 +1,67 @@
+package policy
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLookup(t *testing.T) {
+	for name, pin := range map[string]Pin{
+		"semver": PinTag, "SemVer": PinTag, "regex": PinTag, "numerical": PinTag, "alphabetical": PinTag,
+		"calver": PinTag, "latest": PinTag, "digest": PinDigest, "manual": PinNone,
+	} {
+		p, err := Lookup(name)
+		if err != nil {
+			t.Fatalf("Lookup(%q): %v", name, err)
+		}
+		if p.Pin() != pin || p.Name() != strings.ToLower(name) {
+			t.Errorf("Lookup(%q) = %s pinning %v, want %v", name, p.Name(), p.Pin(), pin)
+		}
+	}
+
+	_, err := Lookup("semvar")
+	if err == nil || !strings.Contains(err.Error(), "calver, digest, latest, manual, numerical, regex, semver") {
+		t.Fatalf("want an error listing the policies, got %v", err)
+	}
+	if IsTagPolicy("semvar") || IsTagPolicy("digest") || !IsTagPolicy("calver") {
+		t.Fatal("IsTagPolicy disagrees with the registry")
+	}
+}
+
+func TestRegister_Duplicate(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Fatal("registering a taken name should panic")
+		}
+	}()
+	Register(followPolicy{name: "Latest", pin: PinTag})
+}
+
+func TestValidate_PolicyOptions(t *testing.T) {
+	for name, opts := range map[string]Options{
+		"regex":     {},
+		"calver":    {Format: "YYYY.QQ"},
+		"semver":    {Format: "YYYY.MM"},
+		"numerical": {Within: WithinYear},
+		"digest":    {Format: "YYYY.MM"},
+	} {
+		p, _ := Lookup(name)
+		if err := p.Validate(opts); err == nil {
+			t.Errorf("%s: want an error for %+v", name, opts)
+		}
+	}
+	p, _ := Lookup("calver")
+	if err := p.Validate(Options{Format: "YYYY.MM.MICRO"}); err != nil {
+		t.Errorf("calver: %v", err)
+	}
+}
+
+func TestResolve_UnknownPolicy(t *testing.T) {
+	d, err := Resolve("newest", []string{"1.0.0"}, "", Options{})
+	if err == nil || d.Error == "" {
+		t.Fatalf("want an error recorded in the decision, got %v / %+v", err, d)
+	}
+	if _, err := Resolve("digest", []string{"1.0.0"}, "", Options{}); err == nil {
+		t.Fatal("digest does not pick tags")
+	}
+}
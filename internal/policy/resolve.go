@@ -0,0 +1,68 @@
+package policy
+
+import (
+	"fmt"
+	"strings"
+)
+
+// DowngradeError is returned by Resolve when the best candidate orders below
+// the tag currently deployed, e.g. because newer tags were deleted upstream.
+type DowngradeError struct {
+	Current   string
+	Candidate string
+}
+
+func (e *DowngradeError) Error() string {
+	return fmt.Sprintf("refusing downgrade from %s to %s (set allowDowngrade to permit)", e.Current, e.Candidate)
+}
+
+// IsTagPolicy reports policies that choose among the repository's tags
+// rather than following the annotated one. Unknown names are not.
+func IsTagPolicy(name string) bool {
+	p, err := Lookup(name)
+	return err == nil && p.PicksTags()
+}
+
+// Resolve runs a tag-picking policy over tags, after removing the ones
+// opts.Skip names, and returns the Decision explaining the outcome even when
+// it fails. current is the tag deployed now; unless opts.AllowDowngrade is
+// set, a candidate that orders below it is refused with a *DowngradeError. A
+// current tag the policy cannot order (e.g. "latest" under semver) never
+// blocks a candidate.
+func Resolve(name string, tags []string, current string, opts Options) (*Decision, error) {
+	d := &Decision{Policy: strings.ToLower(name), Current: current}
+	tag, err := resolve(d, name, tags, opts)
+	if err != nil {
+		d.Error = err.Error()
+		return d, err
+	}
+	d.Chosen = tag
+	return d, nil
+}
+
+func resolve(d *Decision, name string, tags []string, opts Options) (string, error) {
+	p, err := Lookup(name)
+	if err != nil {
+		return "", err
+	}
+	if !p.PicksTags() {
+		return "", fmt.Errorf("policy %q does not pick tags", p.Name())
+	}
+	tags, skipped, err := SkipTags(tags, opts)
+	if err != nil {
+		return "", err
+	}
+	for _, tag := range skipped {
+		d.skip(tag)
+	}
+	tag, err := p.Select(tags, d.Current, opts, d)
+	if err != nil {
+		return "", err
+	}
+	if c, ok := p.(Comparer); ok && !opts.AllowDowngrade && d.Current != "" && tag != d.Current {
+		if cmp, ok := c.Compare(tag, d.Current, opts); ok && cmp < 0 {
+			return "", &DowngradeError{Current: d.Current, Candidate: tag}
+		}
+	}
+	return tag, nil
+}
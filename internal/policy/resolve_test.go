@@ -0,0 +1,51 @@
+package policy
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestResolve_RefusesDowngrade(t *testing.T) {
+	// 1.5.0 was deleted upstream; the highest remaining tag is older
+	tags := []string{"1.3.0", "1.4.0", "latest"}
+	_, err := Resolve("semver", tags, "1.5.0", Options{})
+	var dg *DowngradeError
+	if !errors.As(err, &dg) {
+		t.Fatalf("want *DowngradeError, got %v", err)
+	}
+	if dg.Current != "1.5.0" || dg.Candidate != "1.4.0" {
+		t.Fatalf("got %+v", dg)
+	}
+
+	d, err := Resolve("semver", tags, "1.5.0", Options{AllowDowngrade: true})
+	if err != nil || d.Chosen != "1.4.0" {
+		t.Fatalf("allowDowngrade: want 1.4.0, got %+v, %v", d, err)
+	}
+}
+
+func TestResolve_UnorderedCurrentDoesNotBlock(t *testing.T) {
+	d, err := Resolve("semver", []string{"1.3.0", "1.4.0"}, "latest", Options{})
+	if err != nil || d.Chosen != "1.4.0" {
+		t.Fatalf("want 1.4.0, got %+v, %v", d, err)
+	}
+	if d, err := Resolve("semver", []string{"1.4.0"}, "1.4.0", Options{}); err != nil || d.Chosen != "1.4.0" {
+		t.Fatalf("same tag: got %+v, %v", d, err)
+	}
+}
+
+func TestResolve_DowngradeFollowsSortDirection(t *testing.T) {
+	tags := []string{"build-998", "build-1042"}
+	if _, err := Resolve("numerical", tags, "build-1043", Options{}); err == nil {
+		t.Fatalf("expected numerical downgrade to be refused")
+	}
+	// with desc the lowest number is preferred, so moving down is an upgrade
+	d, err := Resolve("numerical", tags, "build-1042", Options{Sort: SortDesc})
+	if err != nil || d.Chosen != "build-998" {
+		t.Fatalf("desc: want build-998, got %+v, %v", d, err)
+	}
+
+	_, err = Resolve("calver", []string{"2024.4.1", "2024.5.0"}, "2024.6.0", Options{Format: "YYYY.MM.MICRO"})
+	if !errors.As(err, new(*DowngradeError)) {
+		t.Fatalf("calver: want *DowngradeError, got %v", err)
+	}
+}
@@ -9,16 +9,130 @@ import (
 	"github.com/Masterminds/semver/v3"
 )
 
+// semverPolicy follows the highest semantic version, see ResolveSemverFrom.
+type semverPolicy struct{}
+
+func init() { Register(semverPolicy{}) }
+
+func (semverPolicy) Name() string    { return "semver" }
+func (semverPolicy) PicksTags() bool { return true }
+func (semverPolicy) Pin() Pin        { return PinTag }
+
+func (p semverPolicy) Validate(opts Options) error {
+	return calverOnly(p.Name(), opts)
+}
+
+func (semverPolicy) Select(tags []string, current string, opts Options, d *Decision) (string, error) {
+	return resolveSemverFrom(tags, current, opts, d)
+}
+
+// Compare orders a and b as versions of b's variant.
+func (semverPolicy) Compare(a, b string, opts Options) (int, bool) {
+	variant := SemverVariant(b, opts)
+	va, okA := parseVariantTag(a, variant, opts)
+	vb, okB := parseVariantTag(b, variant, opts)
+	if !okA || !okB {
+		return 0, false
+	}
+	return va.Compare(vb), true
+}
+
+// Channel keeps apart the release lines of opts.Update ("@16") and, unless
+// opts.Variant pins one, the variants of current ("-alpine"): nginx
+// 1.25-alpine and 1.25 follow different images.
+func (semverPolicy) Channel(current string, opts Options) string {
+	var ch string
+	if line := UpdateLine(current, opts); line != "" {
+		ch += "@" + line
+	}
+	if opts.Variant == "" {
+		if v := SemverVariant(current, opts); v != "" {
+			ch += "-" + v
+		}
+	}
+	return ch
+}
+
 // semverPattern matches tags like "v1.2.3" or "1.2.3" (optionally with suffixes like -beta)
 var semverPattern = regexp.MustCompile(`^v?(\d+\.\d+\.\d+([\-+].*)?)$`)
 
 // ResolveSemver takes a list of tags and returns the latest semantic version.
-// It ignores non-semver tags (e.g. "main", "latest") and returns an error if none found.
+// It ignores non-semver tags (e.g. "main", "latest") and pre-releases, and
+// returns an error if none found.
 func ResolveSemver(tags []string) (string, error) {
+	return ResolveSemverWith(tags, Options{})
+}
+
+// ResolveSemverWith is ResolveSemver restricted by opts, e.g. to the versions
+// inside opts.Range.
+func ResolveSemverWith(tags []string, opts Options) (string, error) {
+	return resolveSemver(tags, opts, nil, nil)
+}
+
+// ResolveSemverFrom is ResolveSemverWith relative to current, the tag now
+// written in the compose file: opts.Update "patch" keeps to its major.minor
+// line, "minor" to its major version, "major" allows any version. Only tags
+// of current's variant (see SemverVariant) are considered.
+func ResolveSemverFrom(tags []string, current string, opts Options) (string, error) {
+	return resolveSemverFrom(tags, current, opts, nil)
+}
+
+func resolveSemverFrom(tags []string, current string, opts Options, d *Decision) (string, error) {
+	opts.Variant = SemverVariant(current, opts)
+	if opts.Update == "" || opts.Update == UpdateMajor {
+		return resolveSemver(tags, opts, nil, d)
+	}
+	cur, err := parseSemverTag(current)
+	if err != nil {
+		return "", fmt.Errorf("update %q: current tag %q is not semver", opts.Update, current)
+	}
+	return resolveSemver(tags, opts, func(v *semver.Version) bool {
+		if v.Major() != cur.Major() {
+			return false
+		}
+		return opts.Update != UpdatePatch || v.Minor() == cur.Minor()
+	}, d)
+}
+
+// UpdateLine names the release line opts.Update keeps current on: "16" for
+// minor updates, "16.4" for patch updates, "" when unrestricted or current
+// is not semver.
+func UpdateLine(current string, opts Options) string {
+	if opts.Update != UpdatePatch && opts.Update != UpdateMinor {
+		return ""
+	}
+	v, err := parseSemverTag(current)
+	if err != nil {
+		return ""
+	}
+	if opts.Update == UpdatePatch {
+		return fmt.Sprintf("%d.%d", v.Major(), v.Minor())
+	}
+	return fmt.Sprintf("%d", v.Major())
+}
+
+// parseSemverTag parses a tag the way resolution does (optional "v" prefix).
+func parseSemverTag(tag string) (*semver.Version, error) {
+	m := semverPattern.FindStringSubmatch(strings.TrimSpace(tag))
+	if len(m) == 0 {
+		return nil, fmt.Errorf("%q is not a semver tag", tag)
+	}
+	return semver.NewVersion(m[1])
+}
+
+// resolveSemver picks the highest tag allowed by opts and, if set, allow,
+// explaining itself in d when it is not nil.
+func resolveSemver(tags []string, opts Options, allow func(*semver.Version) bool, d *Decision) (string, error) {
 	if len(tags) == 0 {
 		return "", fmt.Errorf("no tags provided")
 	}
 
+	constraint, err := opts.constraint()
+	if err != nil {
+		return "", err
+	}
+
+	variant := strings.ToLower(opts.Variant)
 	var versions []*semver.Version
 	tagMap := make(map[string]string)
 
@@ -28,13 +142,28 @@ func ResolveSemver(tags []string) (string, error) {
 			continue
 		}
 
-		m := semverPattern.FindStringSubmatch(tag)
-		if len(m) == 0 {
+		v, ok := parseVariantTag(tag, variant, opts)
+		if !ok {
+			switch variant {
+			case "":
+				d.reject(tag, "not semver")
+			case VariantNone:
+				d.reject(tag, "not a plain semver version")
+			default:
+				d.reject(tag, "not semver with variant %q", variant)
+			}
 			continue
 		}
-
-		v, err := semver.NewVersion(m[1])
-		if err != nil {
+		if !opts.allowsPrerelease(v.Prerelease()) {
+			d.reject(tag, "pre-release")
+			continue
+		}
+		if constraint != nil && !constraint.Check(v) {
+			d.reject(tag, "outside range %q", opts.Range)
+			continue
+		}
+		if allow != nil && !allow(v) {
+			d.reject(tag, "beyond %s updates", opts.Update)
 			continue
 		}
 
@@ -43,10 +172,26 @@ func ResolveSemver(tags []string) (string, error) {
 	}
 
 	if len(versions) == 0 {
+		if allow != nil {
+			return "", fmt.Errorf("no semver tags within the allowed %s updates", opts.Update)
+		}
+		if constraint != nil {
+			return "", fmt.Errorf("no semver tags satisfy range %q", opts.Range)
+		}
+		if variant != "" && variant != VariantNone {
+			return "", fmt.Errorf("no semver tags with variant %q", variant)
+		}
 		return "", fmt.Errorf("no valid semver tags found in list")
 	}
 
 	sort.Sort(semver.Collection(versions))
+	if d != nil {
+		best := make([]string, len(versions))
+		for i, v := range versions {
+			best[len(versions)-1-i] = tagMap[v.Original()]
+		}
+		d.rank(best)
+	}
 	latest := versions[len(versions)-1]
 	return tagMap[latest.Original()], nil
 }
@@ -39,15 +39,65 @@ func TestResolveSemver_StableBeatsPrerelease(t *testing.T) {
 
 func TestResolveSemver_OnlyPrereleases(t *testing.T) {
 	tags := []string{"v2.0.0-beta.1", "v2.0.0-beta.2"}
-	got, err := ResolveSemver(tags)
+	if got, err := ResolveSemver(tags); err == nil {
+		t.Fatalf("prereleases are excluded by default, got %q", got)
+	}
+	got, err := ResolveSemverWith(tags, Options{Prerelease: "*"})
 	if err != nil {
-		t.Fatalf("ResolveSemver error: %v", err)
+		t.Fatalf("ResolveSemverWith error: %v", err)
 	}
 	if got != "v2.0.0-beta.2" {
 		t.Fatalf("want v2.0.0-beta.2, got %q", got)
 	}
 }
 
+func TestResolveSemver_SkipsNewerPrerelease(t *testing.T) {
+	tags := []string{"1.1.0", "1.2.0-rc.1", "1.2.0-rc.2"}
+	got, err := ResolveSemver(tags)
+	if err != nil {
+		t.Fatalf("ResolveSemver error: %v", err)
+	}
+	if got != "1.1.0" {
+		t.Fatalf("want 1.1.0 (rc not deployed by default), got %q", got)
+	}
+}
+
+func TestResolveSemverWith_PrereleaseOrdering(t *testing.T) {
+	// opted in, an rc wins until its stable release exists
+	got, err := ResolveSemverWith([]string{"1.1.0", "1.2.0-rc.1", "1.2.0-rc.2"}, Options{Prerelease: "rc"})
+	if err != nil || got != "1.2.0-rc.2" {
+		t.Fatalf("want 1.2.0-rc.2, got %q, %v", got, err)
+	}
+	got, err = ResolveSemverWith([]string{"1.2.0-rc.2", "1.2.0", "1.2.0-rc.1"}, Options{Prerelease: "rc"})
+	if err != nil || got != "1.2.0" {
+		t.Fatalf("want 1.2.0 over 1.2.0-rc.2, got %q, %v", got, err)
+	}
+}
+
+func TestResolveSemverWith_PrereleaseChannel(t *testing.T) {
+	tags := []string{"2.0.0", "2.1.0-beta.3", "2.1.0-rc1", "2.2.0-alpha.1"}
+	cases := map[string]string{
+		"":     "2.0.0",
+		"rc":   "2.1.0-rc1",
+		"beta": "2.1.0-beta.3",
+		"*":    "2.2.0-alpha.1",
+	}
+	for channel, want := range cases {
+		got, err := ResolveSemverWith(tags, Options{Prerelease: channel})
+		if err != nil || got != want {
+			t.Fatalf("prerelease %q: want %s, got %q, %v", channel, want, got, err)
+		}
+	}
+}
+
+func TestResolveSemverWith_PrereleaseInRange(t *testing.T) {
+	tags := []string{"1.9.0", "2.0.0-rc.1"}
+	got, err := ResolveSemverWith(tags, Options{Range: ">=1.0.0 <3.0.0", Prerelease: "rc"})
+	if err != nil || got != "2.0.0-rc.1" {
+		t.Fatalf("want 2.0.0-rc.1 inside the range, got %q, %v", got, err)
+	}
+}
+
 func TestResolveSemver_IgnoresNonSemver(t *testing.T) {
 	tags := []string{"main", "latest", "develop"}
 	_, err := ResolveSemver(tags)
@@ -66,3 +116,83 @@ func TestResolveSemver_WhitespaceAndEmpty(t *testing.T) {
 		t.Fatalf("want v3.1.4, got %q", got)
 	}
 }
+
+func TestResolveSemverWith_Range(t *testing.T) {
+	tags := []string{"15.8", "15.8.0", "16.3.0", "16.4.0", "17.0.0", "latest"}
+	got, err := ResolveSemverWith(tags, Options{Range: ">=16.0.0 <17.0.0"})
+	if err != nil {
+		t.Fatalf("ResolveSemverWith error: %v", err)
+	}
+	if got != "16.4.0" {
+		t.Fatalf("want 16.4.0 (stay on 16.x), got %q", got)
+	}
+
+	got, err = ResolveSemverWith(tags, Options{Range: "~15"})
+	if err != nil || got != "15.8.0" {
+		t.Fatalf("want 15.8.0 for ~15, got %q, %v", got, err)
+	}
+}
+
+func TestResolveSemverWith_NothingInRange(t *testing.T) {
+	_, err := ResolveSemverWith([]string{"1.0.0", "1.1.0"}, Options{Range: "^2"})
+	if err == nil {
+		t.Fatalf("expected error when no tag satisfies the range")
+	}
+}
+
+func TestOptionsValidate(t *testing.T) {
+	if err := (Options{Range: ">=16 <17"}).Validate(); err != nil {
+		t.Fatalf("valid range rejected: %v", err)
+	}
+	if err := (Options{Range: "sixteen"}).Validate(); err == nil {
+		t.Fatalf("expected error for a bad range")
+	}
+	if err := (Options{Prerelease: "rc.1"}).Validate(); err == nil {
+		t.Fatalf("expected error for a bad prerelease channel")
+	}
+}
+
+func TestResolveSemverFrom_UpdateLevels(t *testing.T) {
+	tags := []string{"1.2.3", "1.2.9", "1.3.0", "1.4.2", "2.0.0", "2.1.0-rc.1"}
+	cases := map[string]string{
+		UpdatePatch: "1.2.9",
+		UpdateMinor: "1.4.2",
+		UpdateMajor: "2.0.0",
+		"":          "2.0.0",
+	}
+	for update, want := range cases {
+		got, err := ResolveSemverFrom(tags, "v1.2.3", Options{Update: update})
+		if err != nil || got != want {
+			t.Fatalf("update %q: want %s, got %q, %v", update, want, got, err)
+		}
+	}
+}
+
+func TestResolveSemverFrom_CombinesWithRange(t *testing.T) {
+	tags := []string{"16.3.0", "16.4.0", "16.5.0", "17.0.0"}
+	got, err := ResolveSemverFrom(tags, "16.3.0", Options{Update: UpdateMinor, Range: "<16.5.0"})
+	if err != nil || got != "16.4.0" {
+		t.Fatalf("want 16.4.0, got %q, %v", got, err)
+	}
+}
+
+func TestResolveSemverFrom_NeedsSemverCurrent(t *testing.T) {
+	if _, err := ResolveSemverFrom([]string{"1.0.0"}, "latest", Options{Update: UpdatePatch}); err == nil {
+		t.Fatalf("expected error for a non-semver current tag")
+	}
+	if err := (Options{Update: "any"}).Validate(); err == nil {
+		t.Fatalf("expected error for an unknown update level")
+	}
+}
+
+func TestUpdateLine(t *testing.T) {
+	if got := UpdateLine("v16.4.2", Options{Update: UpdatePatch}); got != "16.4" {
+		t.Fatalf("patch line = %q", got)
+	}
+	if got := UpdateLine("16.4.2", Options{Update: UpdateMinor}); got != "16" {
+		t.Fatalf("minor line = %q", got)
+	}
+	if got := UpdateLine("16.4.2", Options{Update: UpdateMajor}); got != "" {
+		t.Fatalf("major line = %q", got)
+	}
+}
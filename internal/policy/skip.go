@@ -0,0 +1,110 @@
+package policy
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/Masterminds/semver/v3"
+)
+
+// skipRule is one Options.Skip entry: an exact tag, or a semver constraint
+// such as ">=2.0.0 <2.0.3" or "~2.1" matched against the tag's version.
+type skipRule struct {
+	tag        string
+	constraint *semver.Constraints
+}
+
+// skipRules compiles Options.Skip. An entry is a constraint only when it
+// starts with an operator or holds a space or comma, and must then parse as
+// one; anything else is an exact tag, so "1.25" skips the tag 1.25 and not
+// every 1.25.x release.
+func (o Options) skipRules() ([]skipRule, error) {
+	var rules []skipRule
+	for _, raw := range o.Skip {
+		raw = strings.TrimSpace(raw)
+		if raw == "" {
+			continue
+		}
+		r := skipRule{tag: raw}
+		if isSkipConstraint(raw) {
+			c, err := semver.NewConstraint(raw)
+			if err != nil {
+				return nil, fmt.Errorf("skip %q: %w", raw, err)
+			}
+			c.IncludePrerelease = true
+			r.constraint = c
+		}
+		rules = append(rules, r)
+	}
+	return rules, nil
+}
+
+func isSkipConstraint(raw string) bool {
+	return strings.ContainsAny(raw[:1], "<>=~^!") || strings.ContainsAny(raw, " ,")
+}
+
+// SkipTags splits tags into the ones opts.Skip leaves for selection and the
+// ones it removes.
+func SkipTags(tags []string, opts Options) (kept, skipped []string, err error) {
+	rules, err := opts.skipRules()
+	if err != nil || len(rules) == 0 {
+		return tags, nil, err
+	}
+	for _, tag := range tags {
+		if skipMatch(rules, strings.TrimSpace(tag), opts) {
+			skipped = append(skipped, tag)
+			continue
+		}
+		kept = append(kept, tag)
+	}
+	return kept, skipped, nil
+}
+
+func skipMatch(rules []skipRule, tag string, opts Options) bool {
+	// 1.25.4-alpine is skipped by a rule for 1.25.4
+	base := tag
+	if variant := detectVariant(tag, opts); variant != "" {
+		base = tag[:len(tag)-len(variant)-1]
+	}
+	var (
+		v      *semver.Version
+		parsed bool
+	)
+	for _, r := range rules {
+		if r.constraint == nil {
+			if strings.EqualFold(r.tag, tag) || strings.EqualFold(r.tag, base) {
+				return true
+			}
+			continue
+		}
+		if !parsed {
+			v, _ = parseSemverTag(base)
+			parsed = true
+		}
+		if v != nil && r.constraint.Check(v) {
+			return true
+		}
+	}
+	return false
+}
+
+// DenyList is the repository-wide skip list: image references (without a
+// tag) mapped to skip entries, which are added to every target of that image.
+type DenyList map[string][]string
+
+// ParseDenyList reads a deny-list file such as
+//
+//	{"ghcr.io/acme/api": ["1.4.2"], "docker.io/library/redis": [">=7.2.0 <7.2.3"]}
+func ParseDenyList(data []byte) (DenyList, error) {
+	var dl DenyList
+	if err := json.Unmarshal(data, &dl); err != nil {
+		return nil, fmt.Errorf("deny list: %w", err)
+	}
+	for image, entries := range dl {
+		if _, err := (Options{Skip: entries}).skipRules(); err != nil {
+			return nil, fmt.Errorf("deny list %s: %w", image, err)
+		}
+	}
+	return dl, nil
+}
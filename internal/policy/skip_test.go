@@ -0,0 +1,74 @@
+package policy
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestResolve_SkipsDeniedVersions(t *testing.T) {
+	tags := []string{"1.24.0", "1.25.3", "1.25.4", "1.25.5-rc.1", "1.25.5"}
+	d, err := Resolve("semver", tags, "1.24.0", Options{Skip: []string{"1.25.5"}})
+	if err != nil || d.Chosen != "1.25.4" {
+		t.Fatalf("exact: want 1.25.4, got %+v, %v", d, err)
+	}
+	if skipped := d.Skipped(); strings.Join(skipped, ",") != "1.25.5" {
+		t.Fatalf("skipped = %v", skipped)
+	}
+
+	// a range also covers the pre-releases inside it
+	d, err = Resolve("semver", tags, "1.24.0", Options{Skip: []string{">=1.25.4 <1.26.0"}, Prerelease: "*"})
+	if err != nil || d.Chosen != "1.25.3" {
+		t.Fatalf("range: want 1.25.3, got %+v, %v", d, err)
+	}
+	if skipped := d.Skipped(); strings.Join(skipped, ",") != "1.25.4,1.25.5-rc.1,1.25.5" {
+		t.Fatalf("skipped = %v", skipped)
+	}
+}
+
+func TestResolve_SkippedSurvivesTruncation(t *testing.T) {
+	tags := []string{"0.9.0"}
+	for i := 0; i < explainLimit+10; i++ {
+		tags = append(tags, fmt.Sprintf("1.%d.0", i))
+	}
+	d, err := Resolve("semver", tags, "", Options{Skip: []string{">=1.0.0"}})
+	if err != nil || d.Chosen != "0.9.0" {
+		t.Fatalf("want 0.9.0, got %+v, %v", d, err)
+	}
+	if d.Omitted != 10 {
+		t.Fatalf("expected a truncated decision, omitted %d", d.Omitted)
+	}
+	if n := len(d.Skipped()); n != explainLimit+10 {
+		t.Fatalf("skipped %d tags, want %d", n, explainLimit+10)
+	}
+}
+
+func TestSkipTags_PartialVersionIsATag(t *testing.T) {
+	tags := []string{"1.25", "1.25.4", "1.25.5", "1.25.5-alpine"}
+	kept, skipped, err := SkipTags(tags, Options{Skip: []string{"1.25"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(kept, ",") != "1.25.4,1.25.5,1.25.5-alpine" || strings.Join(skipped, ",") != "1.25" {
+		t.Fatalf("kept %v, skipped %v", kept, skipped)
+	}
+
+	d, err := Resolve("semver", []string{"1.25.4", "1.25.5"}, "1.25.4", Options{Skip: []string{"1.25"}})
+	if err != nil || d.Chosen != "1.25.5" {
+		t.Fatalf("skip 1.25 must not reject 1.25.5, got %+v, %v", d, err)
+	}
+}
+
+func TestSkipTags_VariantsAndExactTags(t *testing.T) {
+	tags := []string{"1.25.4-alpine", "1.25.3-alpine", "build-7", "build-8"}
+	kept, skipped, err := SkipTags(tags, Options{Skip: []string{"1.25.4", "BUILD-8"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(kept, ",") != "1.25.3-alpine,build-7" || strings.Join(skipped, ",") != "1.25.4-alpine,build-8" {
+		t.Fatalf("kept %v, skipped %v", kept, skipped)
+	}
+	if err := (Options{Skip: []string{">=banana"}}).Validate(); err == nil {
+		t.Fatalf("expected error for a malformed skip constraint")
+	}
+}
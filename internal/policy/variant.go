@@ -0,0 +1,80 @@
+package policy
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/Masterminds/semver/v3"
+)
+
+// VariantNone as Options.Variant keeps to plain tags (1.25.3, not
+// 1.25.3-alpine) even when the current tag carries a suffix.
+const VariantNone = "none"
+
+// variantPattern is what an image variant suffix may look like: alpine,
+// bookworm-slim, alpine3.19.
+var variantPattern = regexp.MustCompile(`^[0-9A-Za-z][0-9A-Za-z._-]*$`)
+
+// prereleaseChannels are suffixes that mark a pre-release rather than an
+// image variant when the variant is detected from the current tag.
+var prereleaseChannels = map[string]bool{
+	"alpha": true, "a": true, "beta": true, "b": true, "rc": true, "pre": true,
+	"preview": true, "dev": true, "snapshot": true, "canary": true, "nightly": true,
+}
+
+// SemverVariant is the variant suffix semver resolution keeps to for
+// current: opts.Variant when set, otherwise the suffix of current itself
+// ("alpine" for 1.25.3-alpine). It is "" when any suffix goes, and
+// VariantNone when only plain versions do.
+func SemverVariant(current string, opts Options) string {
+	if opts.Variant != "" {
+		return strings.ToLower(opts.Variant)
+	}
+	return detectVariant(current, opts)
+}
+
+// detectVariant returns the suffix of a semver tag unless it reads as a
+// pre-release (1.2.0-rc.1, 1.2.0-beta2, 7.2.4-1).
+func detectVariant(tag string, opts Options) string {
+	m := semverPattern.FindStringSubmatch(strings.TrimSpace(tag))
+	if len(m) == 0 || !strings.HasPrefix(m[2], "-") {
+		return ""
+	}
+	suffix := m[2][1:]
+	if i := strings.IndexByte(suffix, '+'); i >= 0 {
+		suffix = suffix[:i]
+	}
+	first, _, _ := strings.Cut(suffix, ".")
+	if first == "" || first[0] >= '0' && first[0] <= '9' {
+		return ""
+	}
+	channel := strings.ToLower(strings.TrimRight(first, "0123456789"))
+	if prereleaseChannels[channel] || (opts.Prerelease != "" && strings.EqualFold(channel, opts.Prerelease)) {
+		return ""
+	}
+	return strings.ToLower(suffix)
+}
+
+// parseVariantTag parses tag as semver once its variant suffix is removed;
+// ok is false for tags of another variant. With VariantNone, tags that carry
+// any variant are rejected.
+func parseVariantTag(tag, variant string, opts Options) (v *semver.Version, ok bool) {
+	switch variant {
+	case "":
+	case VariantNone:
+		if detectVariant(tag, opts) != "" {
+			return nil, false
+		}
+	default:
+		n := len(tag) - len(variant) - 1
+		if n <= 0 || tag[n] != '-' || !strings.EqualFold(tag[n+1:], variant) {
+			return nil, false
+		}
+		tag = tag[:n]
+	}
+	v, err := parseSemverTag(tag)
+	if err != nil {
+		return nil, false
+	}
+	return v, true
+}
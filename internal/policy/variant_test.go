@@ -0,0 +1,55 @@
+package policy
+
+import "testing"
+
+func TestResolveSemverFrom_KeepsVariant(t *testing.T) {
+	tags := []string{"1.25.3", "1.25.3-alpine", "1.25.4", "1.25.4-alpine", "1.26.0", "1.26.0-bookworm", "1.26.0-rc.1-alpine"}
+	got, err := ResolveSemverFrom(tags, "1.25.3-alpine", Options{})
+	if err != nil || got != "1.25.4-alpine" {
+		t.Fatalf("alpine: want 1.25.4-alpine, got %q, %v", got, err)
+	}
+	got, err = ResolveSemverFrom(tags, "1.25.3-alpine", Options{Prerelease: "rc"})
+	if err != nil || got != "1.26.0-rc.1-alpine" {
+		t.Fatalf("alpine rc: want 1.26.0-rc.1-alpine, got %q, %v", got, err)
+	}
+	got, err = ResolveSemverFrom(tags, "1.25.3", Options{})
+	if err != nil || got != "1.26.0" {
+		t.Fatalf("plain: want 1.26.0, got %q, %v", got, err)
+	}
+	got, err = ResolveSemverFrom(tags, "1.25.3", Options{Variant: "bookworm"})
+	if err != nil || got != "1.26.0-bookworm" {
+		t.Fatalf("explicit: want 1.26.0-bookworm, got %q, %v", got, err)
+	}
+	// "none" also keeps a prerelease opt-in from picking up variants
+	got, err = ResolveSemverFrom(tags, "1.25.3-alpine", Options{Variant: VariantNone, Prerelease: "*"})
+	if err != nil || got != "1.26.0" {
+		t.Fatalf("none: want 1.26.0, got %q, %v", got, err)
+	}
+	if _, err := ResolveSemverFrom(tags, "1.25.3-slim", Options{}); err == nil {
+		t.Fatalf("expected error when no tag has the variant")
+	}
+}
+
+func TestSemverVariant_Detection(t *testing.T) {
+	for tag, want := range map[string]string{
+		"1.25.3-alpine":        "alpine",
+		"7.2.4-Bookworm-slim":  "bookworm-slim",
+		"v3.1.0-alpine3.19":    "alpine3.19",
+		"1.2.0-rc.1":           "",
+		"1.2.0-beta2":          "",
+		"7.2.4-1":              "",
+		"1.2.0":                "",
+		"latest":               "",
+		"1.2.0-alpine+build.7": "alpine",
+	} {
+		if got := SemverVariant(tag, Options{}); got != want {
+			t.Errorf("%s: got %q, want %q", tag, got, want)
+		}
+	}
+	if got := SemverVariant("1.2.0-nightly", Options{Variant: "Alpine"}); got != "alpine" {
+		t.Errorf("explicit variant: got %q", got)
+	}
+	if err := (Options{Variant: "-x"}).Validate(); err == nil {
+		t.Errorf("expected error for a malformed variant")
+	}
+}
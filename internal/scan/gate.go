@@ -0,0 +1,91 @@
+package scan
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// maxListed caps the findings a Result spells out.
+const maxListed = 10
+
+// Result is the outcome of gating one update.
+type Result struct {
+	Scanner   string
+	Threshold string
+	Candidate string
+	Deployed  string
+	New       []Finding // at or above Threshold, absent from Deployed
+	Existing  int       // at or above Threshold, already in Deployed
+	// BaselineErr is set when Deployed could not be scanned; every finding
+	// then counts as new.
+	BaselineErr error
+}
+
+// Allowed reports whether the update may go ahead.
+func (r *Result) Allowed() bool { return len(r.New) == 0 }
+
+// Summary is a one-line description for logs.
+func (r *Result) Summary() string {
+	verdict := "passed"
+	if !r.Allowed() {
+		verdict = "blocked"
+	}
+	s := fmt.Sprintf("vulnerability scan %s (%s, threshold %s): %d new, %d already deployed",
+		verdict, r.Scanner, r.Threshold, len(r.New), r.Existing)
+	if r.BaselineErr != nil {
+		s += " (deployed image not scanned)"
+	}
+	return s
+}
+
+// Message is the decision as written into a commit message.
+func (r *Result) Message() string {
+	var b strings.Builder
+	b.WriteString(r.Summary())
+	fmt.Fprintf(&b, "\n\ncandidate: %s\ndeployed:  %s\n", r.Candidate, r.Deployed)
+	if r.BaselineErr != nil {
+		fmt.Fprintf(&b, "baseline:  %v\n", r.BaselineErr)
+	}
+	for i, f := range r.New {
+		if i == maxListed {
+			fmt.Fprintf(&b, "... and %d more\n", len(r.New)-maxListed)
+			break
+		}
+		fmt.Fprintf(&b, "new: %s\n", f)
+	}
+	return strings.TrimRight(b.String(), "\n")
+}
+
+// Gate scans candidate and the deployed image and reports the findings at
+// or above the threshold that candidate would introduce. A candidate that
+// cannot be scanned is an error, so the caller can fail closed.
+func (s *Scanner) Gate(ctx context.Context, candidate, deployed string) (*Result, error) {
+	found, err := s.Scan(ctx, candidate)
+	if err != nil {
+		return nil, err
+	}
+	res := &Result{Scanner: s.Name(), Threshold: s.Threshold, Candidate: candidate, Deployed: deployed}
+
+	known := map[string]bool{}
+	if base, err := s.Scan(ctx, deployed); err != nil {
+		res.BaselineErr = err
+	} else {
+		for _, f := range base {
+			known[f.key()] = true
+		}
+	}
+
+	floor := severities[s.Threshold]
+	for _, f := range found {
+		if severities[f.Severity] < floor {
+			continue
+		}
+		if known[f.key()] {
+			res.Existing++
+			continue
+		}
+		res.New = append(res.New, f)
+	}
+	return res, nil
+}
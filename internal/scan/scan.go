@@ -0,0 +1,183 @@
+package scan
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// imagePlaceholder in the scanner command is replaced by the image to scan;
+// without it the image is appended as the last argument.
+const imagePlaceholder = "{image}"
+
+// scanTimeout bounds one scanner run, including a vulnerability DB download.
+const scanTimeout = 10 * time.Minute
+
+// Severities in ascending order; scanners' spellings are matched
+// case-insensitively.
+var severities = map[string]int{
+	"unknown":    0,
+	"negligible": 1,
+	"low":        2,
+	"medium":     3,
+	"high":       4,
+	"critical":   5,
+}
+
+// Finding is one vulnerability in one package.
+type Finding struct {
+	ID       string
+	Package  string
+	Version  string
+	Severity string // lower case
+}
+
+func (f Finding) key() string { return f.ID + " " + f.Package }
+
+func (f Finding) String() string {
+	return fmt.Sprintf("%s (%s %s, %s)", f.ID, f.Package, f.Version, f.Severity)
+}
+
+// Scanner runs a Trivy or Grype command that prints a JSON report.
+type Scanner struct {
+	Command   []string
+	Threshold string // lowest severity that blocks an update
+}
+
+// Load reads MD_SCAN_COMMAND, e.g. "trivy image --quiet --format json {image}"
+// or "grype {image} -o json", and MD_SCAN_SEVERITY (default "high"). It
+// returns nil when no command is configured.
+func Load() (*Scanner, error) {
+	cmd := strings.Fields(os.Getenv("MD_SCAN_COMMAND"))
+	if len(cmd) == 0 {
+		return nil, nil
+	}
+	threshold := strings.ToLower(strings.TrimSpace(os.Getenv("MD_SCAN_SEVERITY")))
+	if threshold == "" {
+		threshold = "high"
+	}
+	if _, ok := severities[threshold]; !ok {
+		return nil, fmt.Errorf("MD_SCAN_SEVERITY: unknown severity %q", threshold)
+	}
+	return &Scanner{Command: cmd, Threshold: threshold}, nil
+}
+
+// Name is the scanner binary, for logs and commit messages.
+func (s *Scanner) Name() string {
+	return filepath.Base(s.Command[0])
+}
+
+// Scan runs the scanner against image and parses its report.
+func (s *Scanner) Scan(ctx context.Context, image string) ([]Finding, error) {
+	args := make([]string, 0, len(s.Command))
+	placed := false
+	for _, a := range s.Command[1:] {
+		if strings.Contains(a, imagePlaceholder) {
+			a = strings.ReplaceAll(a, imagePlaceholder, image)
+			placed = true
+		}
+		args = append(args, a)
+	}
+	if !placed {
+		args = append(args, image)
+	}
+
+	cctx, cancel := context.WithTimeout(ctx, scanTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(cctx, s.Command[0], args...)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		if cctx.Err() == context.DeadlineExceeded {
+			return nil, fmt.Errorf("%s %s: timeout", s.Name(), image)
+		}
+		return nil, fmt.Errorf("%s %s: %v: %s", s.Name(), image, err, strings.TrimSpace(lastLine(stderr.String())))
+	}
+	findings, err := ParseReport(stdout.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("%s %s: %w", s.Name(), image, err)
+	}
+	return findings, nil
+}
+
+func lastLine(s string) string {
+	s = strings.TrimSpace(s)
+	if i := strings.LastIndexByte(s, '\n'); i >= 0 {
+		return s[i+1:]
+	}
+	return s
+}
+
+// ParseReport reads a Trivy ("Results") or Grype ("matches") JSON report.
+// Trivy leaves Results out for images without packages (scratch,
+// distroless), so a report with its SchemaVersion and ArtifactName alone is
+// a clean one. Findings are deduplicated by vulnerability and package.
+func ParseReport(data []byte) ([]Finding, error) {
+	var r struct {
+		SchemaVersion int
+		ArtifactName  string
+		Results       *[]struct {
+			Vulnerabilities []struct {
+				VulnerabilityID  string
+				PkgName          string
+				InstalledVersion string
+				Severity         string
+			}
+		}
+		Matches *[]struct {
+			Vulnerability struct {
+				ID       string `json:"id"`
+				Severity string `json:"severity"`
+			} `json:"vulnerability"`
+			Artifact struct {
+				Name    string `json:"name"`
+				Version string `json:"version"`
+			} `json:"artifact"`
+		} `json:"matches"`
+	}
+	if err := json.Unmarshal(data, &r); err != nil {
+		return nil, fmt.Errorf("parse report: %w", err)
+	}
+
+	var out []Finding
+	switch {
+	case r.Results != nil:
+		for _, res := range *r.Results {
+			for _, v := range res.Vulnerabilities {
+				out = append(out, Finding{v.VulnerabilityID, v.PkgName, v.InstalledVersion, strings.ToLower(v.Severity)})
+			}
+		}
+	case r.Matches != nil:
+		for _, m := range *r.Matches {
+			out = append(out, Finding{m.Vulnerability.ID, m.Artifact.Name, m.Artifact.Version, strings.ToLower(m.Vulnerability.Severity)})
+		}
+	case r.SchemaVersion > 0 && r.ArtifactName != "":
+		// a Trivy report with nothing to scan
+	default:
+		return nil, fmt.Errorf("parse report: neither a Trivy nor a Grype JSON report")
+	}
+
+	seen := make(map[string]bool, len(out))
+	dedup := out[:0]
+	for _, f := range out {
+		if !seen[f.key()] {
+			seen[f.key()] = true
+			dedup = append(dedup, f)
+		}
+	}
+	sort.SliceStable(dedup, func(i, j int) bool {
+		if a, b := severities[dedup[i].Severity], severities[dedup[j].Severity]; a != b {
+			return a > b
+		}
+		return dedup[i].ID < dedup[j].ID
+	})
+	return dedup, nil
+}
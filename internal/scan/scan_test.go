@@ -0,0 +1,131 @@
+package scan
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const trivyReport = `{
+  "SchemaVersion": 2,
+  "ArtifactName": "ghcr.io/acme/api:1.2.0",
+  "Results": [
+    {"Target": "alpine 3.19", "Vulnerabilities": [
+      {"VulnerabilityID": "CVE-2024-0001", "PkgName": "openssl", "InstalledVersion": "3.1.4", "Severity": "CRITICAL"},
+      {"VulnerabilityID": "CVE-2024-0002", "PkgName": "busybox", "InstalledVersion": "1.36", "Severity": "LOW"}
+    ]},
+    {"Target": "app", "Vulnerabilities": [
+      {"VulnerabilityID": "CVE-2024-0001", "PkgName": "openssl", "InstalledVersion": "3.1.4", "Severity": "CRITICAL"}
+    ]},
+    {"Target": "clean"}
+  ]
+}`
+
+const grypeReport = `{
+  "matches": [
+    {"vulnerability": {"id": "GHSA-xxxx", "severity": "Medium"}, "artifact": {"name": "lodash", "version": "4.17.20"}},
+    {"vulnerability": {"id": "CVE-2024-0003", "severity": "High"}, "artifact": {"name": "zlib", "version": "1.3"}}
+  ]
+}`
+
+// trivyScratchReport is what Trivy prints for an image without packages.
+const trivyScratchReport = `{
+  "SchemaVersion": 2,
+  "CreatedAt": "2026-10-16T09:12:44Z",
+  "ArtifactName": "gcr.io/distroless/static@sha256:1f2e",
+  "ArtifactType": "container_image",
+  "Metadata": {"ImageID": "sha256:9a8b", "DiffIDs": ["sha256:7c6d"]}
+}`
+
+func TestParseReport(t *testing.T) {
+	got, err := ParseReport([]byte(trivyReport))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[0].ID != "CVE-2024-0001" || got[0].Severity != "critical" || got[1].Package != "busybox" {
+		t.Fatalf("trivy: %+v", got)
+	}
+
+	got, err = ParseReport([]byte(grypeReport))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[0].ID != "CVE-2024-0003" || got[1].Severity != "medium" {
+		t.Fatalf("grype: %+v", got)
+	}
+
+	if _, err := ParseReport([]byte(`{"foo": 1}`)); err == nil {
+		t.Fatal("want an error for an unknown report")
+	}
+	if _, err := ParseReport([]byte(`{"Results": []}`)); err != nil {
+		t.Fatalf("empty trivy report: %v", err)
+	}
+	if got, err := ParseReport([]byte(trivyScratchReport)); err != nil || len(got) != 0 {
+		t.Fatalf("trivy report without results: %+v, %v", got, err)
+	}
+	if _, err := ParseReport([]byte(`{"SchemaVersion": 2}`)); err == nil {
+		t.Fatal("want an error for a report that names no artifact")
+	}
+}
+
+// fakeScanner returns a Scanner whose command prints dir/<image>.json, with
+// "/", ":" and "@" in the image replaced by "_".
+func fakeScanner(t *testing.T, reports map[string]string) *Scanner {
+	t.Helper()
+	dir := t.TempDir()
+	for image, report := range reports {
+		name := strings.NewReplacer("/", "_", ":", "_", "@", "_").Replace(image) + ".json"
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(report), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	script := filepath.Join(dir, "scanner")
+	body := "#!/bin/sh\nexec cat \"" + dir + "/$(echo \"$2\" | tr '/:@' '___').json\"\n"
+	if err := os.WriteFile(script, []byte(body), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	return &Scanner{Command: []string{script, "--format", "{image}"}, Threshold: "high"}
+}
+
+func TestGate(t *testing.T) {
+	s := fakeScanner(t, map[string]string{
+		"acme/api:1.1.0":         grypeReport,
+		"acme/api@sha256:new":    trivyReport,
+		"acme/api@sha256:same":   `{"matches": [{"vulnerability": {"id": "CVE-2024-0003", "severity": "High"}, "artifact": {"name": "zlib", "version": "1.3.1"}}]}`,
+		"acme/api@sha256:broken": `not json`,
+	})
+	ctx := context.Background()
+
+	res, err := s.Gate(ctx, "acme/api@sha256:new", "acme/api:1.1.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Allowed() || len(res.New) != 1 || res.New[0].ID != "CVE-2024-0001" || res.Existing != 0 {
+		t.Fatalf("want CVE-2024-0001 to block, got %+v", res)
+	}
+	if msg := res.Message(); !strings.Contains(msg, "blocked") || !strings.Contains(msg, "new: CVE-2024-0001 (openssl 3.1.4, critical)") {
+		t.Fatalf("message:\n%s", msg)
+	}
+
+	res, err = s.Gate(ctx, "acme/api@sha256:same", "acme/api:1.1.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.Allowed() || res.Existing != 1 {
+		t.Fatalf("a finding already deployed must not block: %+v", res)
+	}
+
+	res, err = s.Gate(ctx, "acme/api@sha256:same", "acme/api:gone")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Allowed() || res.BaselineErr == nil {
+		t.Fatalf("without a baseline every finding is new: %+v", res)
+	}
+
+	if _, err := s.Gate(ctx, "acme/api@sha256:broken", "acme/api:1.1.0"); err == nil {
+		t.Fatal("an unreadable candidate report must fail the gate")
+	}
+}
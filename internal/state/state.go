@@ -5,8 +5,11 @@ import (
 	"errors"
 	"os"
 	"path/filepath"
+	"sort"
 	"sync"
 	"time"
+
+	"github.com/jpvargasdev/magos-dominus/internal/policy"
 )
 
 // Entry captures the last-known info for a single image reference.
@@ -14,8 +17,67 @@ type Entry struct {
 	Digest      string    `json:"digest"`
 	ETag        string    `json:"etag,omitempty"`
 	Policy      string    `json:"policy,omitempty"`
+	Ref         string    `json:"ref,omitempty"` // tag the digest was resolved from
 	LastChecked time.Time `json:"lastChecked"`
 	LastChanged time.Time `json:"lastChanged"`
+	// Verification is the signature check that admitted Digest; nil when
+	// the image has no key.
+	Verification *Verification `json:"verification,omitempty"`
+}
+
+// Check is what the last check of a key found. Checks are kept apart from
+// entries so a target whose first check fails is explained without being
+// seeded.
+type Check struct {
+	Skipped      []string      `json:"skipped,omitempty"`      // tags removed by skip rules
+	Decision     *Decision     `json:"decision,omitempty"`     // why the check ended the way it did
+	Verification *Verification `json:"verification,omitempty"` // signature check of the candidate; nil without a key
+}
+
+// Outcomes of a check, as recorded in Decision.
+const (
+	OutcomeSeeded      = "seeded"       // first digest recorded as the baseline
+	OutcomeUnchanged   = "unchanged"    // same digest as last time
+	OutcomeNotModified = "not-modified" // registry answered 304 to the ETag
+	OutcomeUpdated     = "updated"      // new digest, update event sent
+	OutcomeHeld        = "held"         // new digest, held back (e.g. minAge)
+	OutcomeRefused     = "refused"      // policy refused the candidate (downgrade)
+	OutcomeError       = "error"        // policy or registry failure
+)
+
+// Decision explains the last check of an entry, for "magos-dominus explain".
+type Decision struct {
+	At      time.Time        `json:"at"`
+	Outcome string           `json:"outcome"`
+	Detail  string           `json:"detail,omitempty"`
+	Ref     string           `json:"ref,omitempty"`
+	Digest  string           `json:"digest,omitempty"`
+	Policy  *policy.Decision `json:"policy,omitempty"` // nil for policies that do not pick tags
+}
+
+// Verification is the result of checking a digest's cosign signature.
+type Verification struct {
+	Digest   string    `json:"digest"`
+	Verified bool      `json:"verified"`
+	Key      string    `json:"key,omitempty"` // public key file checked against
+	Detail   string    `json:"detail,omitempty"`
+	At       time.Time `json:"at"`
+}
+
+// Pending is an update waiting to be applied: found outside its target's
+// maintenance window, not yet through the daemon's checks, or held back by a
+// check that failed or blocked it and retried later.
+type Pending struct {
+	File       string    `json:"file"`
+	Line       int       `json:"line,omitempty"` // annotated image line in File
+	Repo       string    `json:"repo"`
+	Ref        string    `json:"ref"`
+	Digest     string    `json:"digest"`
+	Policy     string    `json:"policy"`
+	Discovered time.Time `json:"discovered"`
+	Reason     string    `json:"reason,omitempty"` // why it was queued
+	NotBefore  time.Time `json:"notBefore,omitempty"`
+	Attempts   int       `json:"attempts,omitempty"` // failed or blocked attempts so far
 }
 
 // File is a JSON-backed state store.
@@ -24,6 +86,10 @@ type File struct {
 	mu   sync.Mutex
 	// key: "<registry>/<owner>/<name>:<ref>"
 	entries map[string]Entry
+	// last check of each key, entry or not
+	checks map[string]Check
+	// pending updates, keyed by the daemon's target key
+	pending map[string]Pending
 }
 
 // New creates a new File state store; Load must be called to populate from disk.
@@ -31,6 +97,8 @@ func New(path string) *File {
 	return &File{
 		path:    path,
 		entries: make(map[string]Entry),
+		checks:  make(map[string]Check),
+		pending: make(map[string]Pending),
 	}
 }
 
@@ -49,13 +117,17 @@ func (f *File) Load() error {
 	if err != nil {
 		if errors.Is(err, os.ErrNotExist) {
 			f.entries = make(map[string]Entry)
+			f.checks = make(map[string]Check)
+			f.pending = make(map[string]Pending)
 			return nil
 		}
 		return err
 	}
 	var onDisk struct {
-		Version int               `json:"version"`
-		Entries map[string]Entry  `json:"entries"`
+		Version int                `json:"version"`
+		Entries map[string]Entry   `json:"entries"`
+		Checks  map[string]Check   `json:"checks"`
+		Pending map[string]Pending `json:"pending"`
 	}
 	if err := json.Unmarshal(data, &onDisk); err != nil {
 		return err
@@ -63,7 +135,15 @@ func (f *File) Load() error {
 	if onDisk.Entries == nil {
 		onDisk.Entries = make(map[string]Entry)
 	}
+	if onDisk.Checks == nil {
+		onDisk.Checks = make(map[string]Check)
+	}
+	if onDisk.Pending == nil {
+		onDisk.Pending = make(map[string]Pending)
+	}
 	f.entries = onDisk.Entries
+	f.checks = onDisk.Checks
+	f.pending = onDisk.Pending
 	return nil
 }
 
@@ -76,13 +156,17 @@ func (f *File) Save() error {
 		return errors.New("state: empty path")
 	}
 	payload := struct {
-		Version   int               `json:"version"`
-		UpdatedAt time.Time         `json:"updatedAt"`
-		Entries   map[string]Entry  `json:"entries"`
+		Version   int                `json:"version"`
+		UpdatedAt time.Time          `json:"updatedAt"`
+		Entries   map[string]Entry   `json:"entries"`
+		Checks    map[string]Check   `json:"checks,omitempty"`
+		Pending   map[string]Pending `json:"pending,omitempty"`
 	}{
 		Version:   1,
 		UpdatedAt: time.Now().UTC(),
 		Entries:   f.entries,
+		Checks:    f.checks,
+		Pending:   f.pending,
 	}
 
 	tmp := f.path + ".tmp"
@@ -132,6 +216,7 @@ func (f *File) UpsertDigest(key, digest, etag, policy string) bool {
 	if changed {
 		e.Digest = digest
 		e.LastChanged = now
+		e.Verification = nil
 	}
 	if etag != "" {
 		e.ETag = etag
@@ -142,6 +227,115 @@ func (f *File) UpsertDigest(key, digest, etag, policy string) bool {
 	return changed
 }
 
+// SetRef records the tag the entry's digest was resolved from.
+func (f *File) SetRef(key, ref string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	e := f.entries[key]
+	e.Ref = ref
+	f.entries[key] = e
+}
+
+// SetDecision records how the last check of key ended.
+func (f *File) SetDecision(key string, d Decision) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	c := f.checks[key]
+	c.Decision = &d
+	f.checks[key] = c
+}
+
+// SetVerification records the signature check of key's candidate on the
+// last check. A passing check is also recorded on the entry, so call it once
+// the verified digest has been adopted.
+func (f *File) SetVerification(key string, v Verification) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	c := f.checks[key]
+	c.Verification = &v
+	f.checks[key] = c
+	if e, ok := f.entries[key]; ok && v.Verified {
+		e.Verification = &v
+		f.entries[key] = e
+	}
+}
+
+// SetSkipped records the tags skip rules removed on the last check of key.
+func (f *File) SetSkipped(key string, tags []string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	c := f.checks[key]
+	c.Skipped = tags
+	f.checks[key] = c
+}
+
+// LastCheck returns what the last check of key recorded.
+func (f *File) LastCheck(key string) (Check, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	c, ok := f.checks[key]
+	return c, ok
+}
+
+// Keys returns the keys with an entry or a recorded check, in sorted order.
+func (f *File) Keys() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	keys := make([]string, 0, len(f.entries))
+	for k := range f.entries {
+		keys = append(keys, k)
+	}
+	for k := range f.checks {
+		if _, ok := f.entries[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// QueuePending stores an update to apply later, replacing any update already
+// queued under key.
+func (f *File) QueuePending(key string, p Pending) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.pending[key] = p
+}
+
+// DropPending removes the update queued under key, if any.
+func (f *File) DropPending(key string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.pending, key)
+}
+
+// SettlePending replaces the update queued under key with next, or drops it
+// when next is nil, unless an update for another digest has replaced it
+// since it was read.
+func (f *File) SettlePending(key, digest string, next *Pending) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if p, ok := f.pending[key]; !ok || p.Digest != digest {
+		return
+	}
+	if next == nil {
+		delete(f.pending, key)
+		return
+	}
+	f.pending[key] = *next
+}
+
+// PendingUpdates returns a copy of the queued updates.
+func (f *File) PendingUpdates() map[string]Pending {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	out := make(map[string]Pending, len(f.pending))
+	for k, p := range f.pending {
+		out[k] = p
+	}
+	return out
+}
+
 func policyOrKeep(current, incoming string) string {
 	if incoming != "" {
 		return incoming
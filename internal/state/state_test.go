@@ -63,3 +63,95 @@ func TestUpdateChecked(t *testing.T) {
 		t.Errorf("expected LastChecked to advance")
 	}
 }
+
+func TestPendingRoundTrip(t *testing.T) {
+	path := tmpFile(t)
+	s := New(path)
+	s.QueuePending("/git/a/compose.yml acme/api", Pending{File: "/git/a/compose.yml", Repo: "acme/api", Ref: "1.2.0", Digest: "sha256:a"})
+	s.QueuePending("/git/a/compose.yml acme/api", Pending{File: "/git/a/compose.yml", Repo: "acme/api", Ref: "1.3.0", Digest: "sha256:b"})
+	if err := s.Save(); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	s2 := New(path)
+	if err := s2.Load(); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	got := s2.PendingUpdates()
+	if len(got) != 1 || got["/git/a/compose.yml acme/api"].Ref != "1.3.0" {
+		t.Fatalf("expected the newer update to replace the queued one, got %+v", got)
+	}
+	s2.DropPending("/git/a/compose.yml acme/api")
+	if len(s2.PendingUpdates()) != 0 {
+		t.Fatalf("pending not dropped")
+	}
+}
+
+func TestCheckWithoutEntry(t *testing.T) {
+	path := tmpFile(t)
+	s := New(path)
+	key := "ghcr.io/acme/api:1.0.0"
+	s.SetDecision(key, Decision{Outcome: OutcomeError, Detail: "registry down"})
+	s.SetSkipped(key, []string{"1.0.1"})
+	if err := s.Save(); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	s2 := New(path)
+	if err := s2.Load(); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if _, ok := s2.Get(key); ok {
+		t.Fatalf("a failed first check must not seed an entry")
+	}
+	c, ok := s2.LastCheck(key)
+	if !ok || c.Decision == nil || c.Decision.Outcome != OutcomeError || len(c.Skipped) != 1 {
+		t.Fatalf("check not recorded: %+v", c)
+	}
+	if keys := s2.Keys(); len(keys) != 1 || keys[0] != key {
+		t.Fatalf("Keys() = %v", keys)
+	}
+}
+
+func TestVerificationOnEntry(t *testing.T) {
+	s := New(tmpFile(t))
+	key := "ghcr.io/acme/api:1.0.0"
+	s.UpsertDigest(key, "sha256:a", "", "digest")
+	s.SetVerification(key, Verification{Digest: "sha256:a", Verified: true, Key: "/keys/api.pub"})
+	s.SetVerification(key, Verification{Digest: "sha256:b", Detail: "no signature"})
+
+	e, _ := s.Get(key)
+	if v := e.Verification; v == nil || v.Digest != "sha256:a" {
+		t.Fatalf("a failed check must not replace the entry's verification: %+v", v)
+	}
+	if c, _ := s.LastCheck(key); c.Verification == nil || c.Verification.Digest != "sha256:b" {
+		t.Fatalf("last check: %+v", c.Verification)
+	}
+	s.UpsertDigest(key, "sha256:c", "", "digest")
+	if e, _ := s.Get(key); e.Verification != nil {
+		t.Fatalf("a new digest must drop the old verification: %+v", e.Verification)
+	}
+}
+
+func TestSettlePending(t *testing.T) {
+	s := New(tmpFile(t))
+	key := "/git/a/compose.yml acme/api"
+	s.QueuePending(key, Pending{Repo: "acme/api", Digest: "sha256:a"})
+
+	retry := Pending{Repo: "acme/api", Digest: "sha256:a", Attempts: 1, Reason: "scan failed"}
+	s.SettlePending(key, "sha256:a", &retry)
+	if got := s.PendingUpdates()[key]; got.Attempts != 1 {
+		t.Fatalf("retry not recorded: %+v", got)
+	}
+
+	// a newer digest queued meanwhile survives the settling of the old one
+	s.QueuePending(key, Pending{Repo: "acme/api", Digest: "sha256:b"})
+	s.SettlePending(key, "sha256:a", nil)
+	if got, ok := s.PendingUpdates()[key]; !ok || got.Digest != "sha256:b" {
+		t.Fatalf("newer update dropped: %+v", got)
+	}
+	s.SettlePending(key, "sha256:b", nil)
+	if len(s.PendingUpdates()) != 0 {
+		t.Fatalf("pending not dropped")
+	}
+}
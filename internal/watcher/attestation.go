@@ -0,0 +1,462 @@
+package watcher
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// Attestations attached as OCI 1.1 referrers: a Sigstore bundle (GitHub
+	// "attest-build-provenance"), a bare DSSE envelope, or an in-toto
+	// statement. Cosign's "sha256-<hex>.att" tag holds DSSE envelopes.
+	mediaTypeSigstoreBundle = "application/vnd.dev.sigstore.bundle"
+	mediaTypeDSSE           = "application/vnd.dsse.envelope.v1+json"
+	mediaTypeInToto         = "application/vnd.in-toto+json"
+
+	inTotoPayloadType = "application/vnd.in-toto+json"
+
+	slsaProvenanceV1   = "https://slsa.dev/provenance/v1"
+	slsaProvenanceV0_2 = "https://slsa.dev/provenance/v0.2"
+
+	// Keyless "cosign attest" puts the signing certificate, its chain and
+	// the transparency log entry on the envelope layer.
+	cosignCertificateAnnotation = "dev.sigstore.cosign/certificate"
+	cosignChainAnnotation       = "dev.sigstore.cosign/chain"
+	cosignBundleAnnotation      = "dev.sigstore.cosign/bundle"
+)
+
+// ErrNoProvenance is returned by VerifyProvenance when the digest carries no
+// SLSA provenance attestation at all.
+var ErrNoProvenance = errors.New("no provenance attestation found")
+
+// Provenance is what one signed SLSA provenance statement says about a
+// build.
+type Provenance struct {
+	PredicateType string
+	BuilderID     string
+	Source        string // repository the build ran from, normalized by normalizeSource
+}
+
+// ProvenancePolicy is the provenance an image must have to be deployed.
+// Builder matches the builder ID exactly or, when it has no "@", up to an
+// "@<ref>" suffix; Source is a repository such as "github.com/acme/api".
+// Identity admits keyless signatures whose Fulcio certificate was issued to
+// it, matched like Builder, by Issuer (GitHub Actions when empty).
+type ProvenancePolicy struct {
+	Builder  string `json:"builder"`
+	Source   string `json:"source"`
+	Identity string `json:"identity"`
+	Issuer   string `json:"issuer"`
+}
+
+// IsZero reports a policy that requires nothing.
+func (p ProvenancePolicy) IsZero() bool {
+	return p.Builder == "" && p.Source == "" && p.Identity == "" && p.Issuer == ""
+}
+
+// Admit returns nil when one of provs satisfies p, or an error naming what
+// the closest attestation got wrong.
+func (p ProvenancePolicy) Admit(provs []Provenance) error {
+	if len(provs) == 0 {
+		return ErrNoProvenance
+	}
+	var err error
+	for _, pv := range provs {
+		switch {
+		case p.Builder != "" && !matchBuilder(p.Builder, pv.BuilderID):
+			err = fmt.Errorf("builder %q is not %q", pv.BuilderID, p.Builder)
+		case p.Source != "" && pv.Source != normalizeSource(p.Source):
+			err = fmt.Errorf("source %q is not %q", pv.Source, p.Source)
+		default:
+			return nil
+		}
+	}
+	return err
+}
+
+func matchBuilder(want, got string) bool {
+	if got == want {
+		return true
+	}
+	return !strings.Contains(want, "@") && strings.HasPrefix(got, want+"@")
+}
+
+// normalizeSource reduces "git+https://github.com/Acme/api.git@refs/heads/main"
+// to "github.com/acme/api".
+func normalizeSource(uri string) string {
+	s := strings.TrimPrefix(strings.TrimSpace(uri), "git+")
+	if _, rest, ok := strings.Cut(s, "://"); ok {
+		s = rest
+	}
+	if i := strings.IndexByte(s, '@'); i >= 0 {
+		s = s[:i]
+	}
+	s = strings.TrimSuffix(strings.TrimRight(s, "/"), ".git")
+	return strings.ToLower(s)
+}
+
+// ProvenanceTrust is who may sign provenance: the holder of Key (the
+// image's cosign key), or a keyless signer whose certificate chains to
+// Fulcio and names the policy's Identity. Rekor dates keyless signatures;
+// without a verified log entry the certificate must still be valid.
+type ProvenanceTrust struct {
+	Key    *CosignKey
+	Fulcio *FulcioRoots
+	Rekor  *RekorKeys
+}
+
+// VerifyProvenance fetches the signed provenance attestations of digest and
+// checks them against p.
+func VerifyProvenance(ctx context.Context, r Registry, repo, digest string, p ProvenancePolicy, trust ProvenanceTrust) error {
+	provs, err := FetchProvenance(ctx, r, repo, digest, p, trust)
+	if err != nil {
+		return err
+	}
+	return p.Admit(provs)
+}
+
+// FetchProvenance returns the SLSA provenance statements about digest found
+// among its OCI 1.1 referrers and under cosign's "sha256-<hex>.att" tag
+// whose signature verifies against trust. Statements whose subject is
+// another digest are ignored; unsigned or badly signed ones about digest are
+// an error when nothing else is left.
+func FetchProvenance(ctx context.Context, r Registry, repo, digest string, p ProvenancePolicy, trust ProvenanceTrust) ([]Provenance, error) {
+	var atts []*Manifest
+	m, err := r.Manifest(ctx, repo, strings.Replace(digest, ":", "-", 1)+".att")
+	switch {
+	case err == nil:
+		atts = append(atts, m)
+	case !errors.Is(err, os.ErrNotExist):
+		return nil, fmt.Errorf("attestation manifest: %w", err)
+	}
+
+	if lr, ok := r.(interface {
+		Referrers(ctx context.Context, repo, digest, artifactType string) ([]Descriptor, error)
+	}); ok {
+		refs, err := lr.Referrers(ctx, repo, digest, "")
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("referrers: %w", err)
+		}
+		for _, desc := range refs {
+			if attestationPayload(desc.ArtifactType) == "" {
+				continue
+			}
+			m, err := r.Manifest(ctx, repo, desc.Digest)
+			if err != nil {
+				return nil, fmt.Errorf("referrer %s: %w", desc.Digest, err)
+			}
+			atts = append(atts, m)
+		}
+	}
+
+	var (
+		out      []Provenance
+		rejected int
+		lastErr  error
+	)
+	for _, m := range atts {
+		for _, layer := range m.Layers {
+			kind := attestationPayload(layer.MediaType)
+			if kind == "" {
+				continue
+			}
+			blob, err := r.Blob(ctx, repo, layer.Digest)
+			if err != nil {
+				return nil, fmt.Errorf("attestation %s: %w", layer.Digest, err)
+			}
+			env, ok := readEnvelope(kind, blob, layer.Annotations)
+			if !ok || env.payloadType != inTotoPayloadType {
+				continue
+			}
+			pv, ok := parseStatement(env.payload, digest)
+			if !ok {
+				continue
+			}
+			if err := trust.verify(env, p); err != nil {
+				rejected++
+				lastErr = err
+				continue
+			}
+			out = append(out, pv)
+		}
+	}
+	if len(out) == 0 && rejected > 0 {
+		return nil, fmt.Errorf("%d provenance attestation(s) failed signature verification: %w", rejected, lastErr)
+	}
+	return out, nil
+}
+
+// attestationPayload maps an artifact or layer media type to the form its
+// content takes, or "" for anything that is not an attestation.
+func attestationPayload(mediaType string) string {
+	switch {
+	case strings.HasPrefix(mediaType, mediaTypeSigstoreBundle):
+		return mediaTypeSigstoreBundle
+	case mediaType == mediaTypeDSSE, mediaType == mediaTypeInToto:
+		return mediaType
+	}
+	return ""
+}
+
+// envelope is a DSSE envelope with the material to verify it: signatures,
+// and for keyless signing the certificate chain (leaf first) and the
+// transparency log entry that dates it.
+type envelope struct {
+	payloadType string
+	payload     []byte
+	sigs        [][]byte
+	certs       []*x509.Certificate
+	tlog        *tlogEntry
+}
+
+type dsseJSON struct {
+	PayloadType string `json:"payloadType"`
+	Payload     string `json:"payload"`
+	Signatures  []struct {
+		Sig string `json:"sig"`
+	} `json:"signatures"`
+}
+
+// readEnvelope unwraps a bundle or envelope layer. A bare in-toto statement
+// comes back with no signatures.
+func readEnvelope(kind string, blob []byte, annotations map[string]string) (envelope, bool) {
+	if kind == mediaTypeInToto {
+		return envelope{payloadType: inTotoPayloadType, payload: blob}, true
+	}
+
+	var (
+		env  envelope
+		raw  = blob
+		cert = annotations[cosignCertificateAnnotation] + annotations[cosignChainAnnotation]
+		der  [][]byte
+	)
+	if kind == mediaTypeSigstoreBundle {
+		var bundle struct {
+			VerificationMaterial struct {
+				Certificate *struct {
+					RawBytes []byte `json:"rawBytes"`
+				} `json:"certificate"`
+				X509CertificateChain *struct {
+					Certificates []struct {
+						RawBytes []byte `json:"rawBytes"`
+					} `json:"certificates"`
+				} `json:"x509CertificateChain"`
+				TlogEntries []struct {
+					LogIndex string `json:"logIndex"`
+					LogID    struct {
+						KeyID []byte `json:"keyId"`
+					} `json:"logId"`
+					IntegratedTime   string `json:"integratedTime"`
+					InclusionPromise *struct {
+						SignedEntryTimestamp []byte `json:"signedEntryTimestamp"`
+					} `json:"inclusionPromise"`
+					CanonicalizedBody []byte `json:"canonicalizedBody"`
+				} `json:"tlogEntries"`
+			} `json:"verificationMaterial"`
+			DSSEEnvelope *json.RawMessage `json:"dsseEnvelope"`
+		}
+		if err := json.Unmarshal(blob, &bundle); err != nil || bundle.DSSEEnvelope == nil {
+			return envelope{}, false
+		}
+		raw = *bundle.DSSEEnvelope
+		vm := bundle.VerificationMaterial
+		if vm.Certificate != nil {
+			der = append(der, vm.Certificate.RawBytes)
+		}
+		if vm.X509CertificateChain != nil {
+			for _, c := range vm.X509CertificateChain.Certificates {
+				der = append(der, c.RawBytes)
+			}
+		}
+		if len(vm.TlogEntries) > 0 && vm.TlogEntries[0].InclusionPromise != nil {
+			e := vm.TlogEntries[0]
+			index, err1 := strconv.ParseInt(e.LogIndex, 10, 64)
+			integrated, err2 := strconv.ParseInt(e.IntegratedTime, 10, 64)
+			if err1 == nil && err2 == nil {
+				env.tlog = &tlogEntry{
+					body:           e.CanonicalizedBody,
+					integratedTime: integrated,
+					logIndex:       index,
+					logID:          hex.EncodeToString(e.LogID.KeyID),
+					set:            e.InclusionPromise.SignedEntryTimestamp,
+				}
+			}
+		}
+		cert = ""
+	} else if b := annotations[cosignBundleAnnotation]; b != "" {
+		var rekor struct {
+			SignedEntryTimestamp []byte `json:"SignedEntryTimestamp"`
+			Payload              struct {
+				Body           string `json:"body"`
+				IntegratedTime int64  `json:"integratedTime"`
+				LogIndex       int64  `json:"logIndex"`
+				LogID          string `json:"logID"`
+			} `json:"Payload"`
+		}
+		if json.Unmarshal([]byte(b), &rekor) == nil {
+			if body, err := base64.StdEncoding.DecodeString(rekor.Payload.Body); err == nil {
+				env.tlog = &tlogEntry{
+					body:           body,
+					integratedTime: rekor.Payload.IntegratedTime,
+					logIndex:       rekor.Payload.LogIndex,
+					logID:          rekor.Payload.LogID,
+					set:            rekor.SignedEntryTimestamp,
+				}
+			}
+		}
+	}
+
+	var dsse dsseJSON
+	if err := json.Unmarshal(raw, &dsse); err != nil {
+		return envelope{}, false
+	}
+	payload, err := base64.StdEncoding.DecodeString(dsse.Payload)
+	if err != nil {
+		return envelope{}, false
+	}
+	env.payloadType, env.payload = dsse.PayloadType, payload
+	for _, s := range dsse.Signatures {
+		if sig, err := base64.StdEncoding.DecodeString(s.Sig); err == nil && len(sig) > 0 {
+			env.sigs = append(env.sigs, sig)
+		}
+	}
+	// a certificate that does not parse leaves the envelope keyless-unverifiable
+	env.certs, _ = parseCertificates(der, cert)
+	return env, true
+}
+
+// pae is the DSSE pre-authentication encoding that signatures cover.
+func pae(payloadType string, payload []byte) []byte {
+	return []byte(fmt.Sprintf("DSSEv1 %d %s %d %s", len(payloadType), payloadType, len(payload), payload))
+}
+
+// verify checks that env carries a signature by t.Key, or by a Fulcio
+// certificate issued to p.Identity.
+func (t ProvenanceTrust) verify(env envelope, p ProvenancePolicy) error {
+	if len(env.sigs) == 0 {
+		return errors.New("attestation is not signed")
+	}
+	msg := pae(env.payloadType, env.payload)
+	if t.Key != nil {
+		for _, sig := range env.sigs {
+			if t.Key.verify(msg, sig) {
+				return nil
+			}
+		}
+	}
+	if p.Identity != "" && len(env.certs) > 0 {
+		signedAt, err := t.signedAt(env)
+		if err != nil {
+			return err
+		}
+		if err := t.Fulcio.verify(env.certs, signedAt, p); err != nil {
+			return err
+		}
+		leaf := &CosignKey{Path: "certificate", pub: env.certs[0].PublicKey}
+		for _, sig := range env.sigs {
+			if leaf.verify(msg, sig) {
+				return nil
+			}
+		}
+		return errors.New("signature does not match its certificate")
+	}
+	switch {
+	case t.Key != nil:
+		return fmt.Errorf("not signed with %s", t.Key.Path)
+	case p.Identity == "":
+		return errors.New("no cosign key (MD_COSIGN_KEYS) or identity to verify the signature against")
+	}
+	return errors.New("no signing certificate")
+}
+
+// signedAt is when a keyless envelope was signed: the time its log entry
+// was integrated, once the log's signed entry timestamp verifies and the
+// entry records this payload and certificate. Without an entry or Rekor
+// keys to check it against, the certificate is held to the present.
+func (t ProvenanceTrust) signedAt(env envelope) (time.Time, error) {
+	if env.tlog == nil || t.Rekor == nil {
+		return time.Now(), nil
+	}
+	at, err := t.Rekor.verify(env.tlog)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if err := env.tlog.covers(env.payload, env.certs[0]); err != nil {
+		return time.Time{}, err
+	}
+	return at, nil
+}
+
+// parseStatement reads an in-toto statement when it is SLSA provenance
+// about digest.
+func parseStatement(statement []byte, digest string) (Provenance, bool) {
+	var st struct {
+		Subject []struct {
+			Digest map[string]string `json:"digest"`
+		} `json:"subject"`
+		PredicateType string `json:"predicateType"`
+		Predicate     struct {
+			// v1
+			BuildDefinition struct {
+				ExternalParameters struct {
+					Workflow struct {
+						Repository string `json:"repository"`
+					} `json:"workflow"`
+				} `json:"externalParameters"`
+			} `json:"buildDefinition"`
+			RunDetails struct {
+				Builder struct {
+					ID string `json:"id"`
+				} `json:"builder"`
+			} `json:"runDetails"`
+			// v0.2
+			Builder struct {
+				ID string `json:"id"`
+			} `json:"builder"`
+			Invocation struct {
+				ConfigSource struct {
+					URI string `json:"uri"`
+				} `json:"configSource"`
+			} `json:"invocation"`
+		} `json:"predicate"`
+	}
+	if err := json.Unmarshal(statement, &st); err != nil {
+		return Provenance{}, false
+	}
+
+	algo, hex, _ := strings.Cut(digest, ":")
+	about := false
+	for _, s := range st.Subject {
+		if s.Digest[algo] == hex {
+			about = true
+			break
+		}
+	}
+	if !about {
+		return Provenance{}, false
+	}
+
+	// The source is the repository the build itself ran from; resolved
+	// dependencies and materials also list base images and actions.
+	pv := Provenance{PredicateType: st.PredicateType}
+	switch st.PredicateType {
+	case slsaProvenanceV1:
+		pv.BuilderID = st.Predicate.RunDetails.Builder.ID
+		pv.Source = normalizeSource(st.Predicate.BuildDefinition.ExternalParameters.Workflow.Repository)
+	case slsaProvenanceV0_2:
+		pv.BuilderID = st.Predicate.Builder.ID
+		pv.Source = normalizeSource(st.Predicate.Invocation.ConfigSource.URI)
+	default:
+		return Provenance{}, false
+	}
+	return pv, true
+}
@@ -0,0 +1,422 @@
+package watcher
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// statement builds an in-toto SLSA provenance statement about digest.
+func statement(digest, predicateType string, predicate map[string]any) []byte {
+	b, _ := json.Marshal(map[string]any{
+		"_type":         "https://in-toto.io/Statement/v1",
+		"subject":       []any{map[string]any{"name": "app", "digest": map[string]string{"sha256": strings.TrimPrefix(digest, "sha256:")}}},
+		"predicateType": predicateType,
+		"predicate":     predicate,
+	})
+	return b
+}
+
+// signedEnvelope wraps stmt in a DSSE envelope signed by priv; a nil priv
+// leaves it unsigned.
+func signedEnvelope(t *testing.T, priv *ecdsa.PrivateKey, stmt []byte) map[string]any {
+	t.Helper()
+	sigs := []any{}
+	if priv != nil {
+		sum := sha256.Sum256(pae(inTotoPayloadType, stmt))
+		sig, err := ecdsa.SignASN1(rand.Reader, priv, sum[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		sigs = append(sigs, map[string]any{"sig": base64.StdEncoding.EncodeToString(sig)})
+	}
+	return map[string]any{
+		"payloadType": inTotoPayloadType,
+		"payload":     base64.StdEncoding.EncodeToString(stmt),
+		"signatures":  sigs,
+	}
+}
+
+// attestationManifest stores blob in f and returns a manifest with it as its
+// only layer.
+func attestationManifest(f *fakeRegistry, repo, layerType string, blob []byte) map[string]any {
+	d := sha256Digest(blob)
+	f.mu.Lock()
+	f.blobs[repo+"@"+d] = blob
+	f.mu.Unlock()
+	return map[string]any{
+		"mediaType": mediaTypeOCIManifest,
+		"config":    map[string]any{"mediaType": "application/vnd.oci.empty.v1+json", "digest": d, "size": len(blob)},
+		"layers":    []any{map[string]any{"mediaType": layerType, "digest": d, "size": len(blob)}},
+	}
+}
+
+// testFulcio is a throwaway CA that issues short-lived signing certificates
+// the way Fulcio does.
+type testFulcio struct {
+	cert  *x509.Certificate
+	priv  *ecdsa.PrivateKey
+	roots *FulcioRoots
+}
+
+func newTestFulcio(t *testing.T) *testFulcio {
+	t.Helper()
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test-fulcio"},
+		NotBefore:             time.Now().Add(-24 * time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &priv.PublicKey, priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	roots, err := ParseFulcioRoots(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testFulcio{cert: cert, priv: priv, roots: roots}
+}
+
+// issue returns a signing key and a certificate for identity valid for ten
+// minutes from notBefore.
+func (f *testFulcio) issue(t *testing.T, identity, issuer string, notBefore time.Time) (*ecdsa.PrivateKey, []byte) {
+	t.Helper()
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, _ := url.Parse(identity)
+	iss, _ := asn1.Marshal(issuer)
+	notBefore = notBefore.Truncate(time.Second)
+	tmpl := &x509.Certificate{
+		SerialNumber:    big.NewInt(2),
+		NotBefore:       notBefore,
+		NotAfter:        notBefore.Add(10 * time.Minute),
+		KeyUsage:        x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:     []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+		URIs:            []*url.URL{u},
+		ExtraExtensions: []pkix.Extension{{Id: oidFulcioIssuerV2, Value: iss}},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, f.cert, &priv.PublicKey, f.priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return priv, der
+}
+
+// testRekor is a throwaway transparency log that promises entries the way
+// Rekor does.
+type testRekor struct {
+	priv  *ecdsa.PrivateKey
+	logID string
+	keys  *RekorKeys
+}
+
+func newTestRekor(t *testing.T) *testRekor {
+	t.Helper()
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(&priv.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, err := ParseRekorKeys(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := sha256.Sum256(der)
+	return &testRekor{priv: priv, logID: hex.EncodeToString(id[:]), keys: keys}
+}
+
+// entry logs a dsse entry for stmt signed with cert, integrated at at.
+func (r *testRekor) entry(t *testing.T, stmt, cert []byte, at time.Time) *tlogEntry {
+	t.Helper()
+	sum := sha256.Sum256(stmt)
+	body, _ := json.Marshal(map[string]any{
+		"apiVersion": "0.0.1",
+		"kind":       "dsse",
+		"spec": map[string]any{
+			"payloadHash": map[string]string{"algorithm": "sha256", "value": hex.EncodeToString(sum[:])},
+			"signatures":  []any{map[string]any{"verifier": pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert})}},
+		},
+	})
+	e := &tlogEntry{body: body, integratedTime: at.Unix(), logIndex: 42, logID: r.logID}
+	r.promise(t, e)
+	return e
+}
+
+// promise signs e's SignedEntryTimestamp.
+func (r *testRekor) promise(t *testing.T, e *tlogEntry) {
+	t.Helper()
+	sum := sha256.Sum256(e.promised())
+	set, err := ecdsa.SignASN1(rand.Reader, r.priv, sum[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.set = set
+}
+
+// keylessBundle is a Sigstore bundle as pushed by attest-build-provenance,
+// with tlog as its transparency log entry when not nil.
+func keylessBundle(t *testing.T, priv *ecdsa.PrivateKey, cert []byte, tlog *tlogEntry, stmt []byte) []byte {
+	t.Helper()
+	vm := map[string]any{"certificate": map[string]any{"rawBytes": base64.StdEncoding.EncodeToString(cert)}}
+	if tlog != nil {
+		keyID, _ := hex.DecodeString(tlog.logID)
+		vm["tlogEntries"] = []any{map[string]any{
+			"logIndex":          strconv.FormatInt(tlog.logIndex, 10),
+			"logId":             map[string]any{"keyId": keyID},
+			"integratedTime":    strconv.FormatInt(tlog.integratedTime, 10),
+			"inclusionPromise":  map[string]any{"signedEntryTimestamp": tlog.set},
+			"canonicalizedBody": tlog.body,
+		}}
+	}
+	b, _ := json.Marshal(map[string]any{
+		"mediaType":            "application/vnd.dev.sigstore.bundle.v0.3+json",
+		"verificationMaterial": vm,
+		"dsseEnvelope":         signedEnvelope(t, priv, stmt),
+	})
+	return b
+}
+
+var githubV1 = map[string]any{
+	"buildDefinition": map[string]any{
+		"externalParameters":   map[string]any{"workflow": map[string]any{"repository": "https://github.com/acme/api", "ref": "refs/heads/main"}},
+		"resolvedDependencies": []any{map[string]any{"uri": "git+https://github.com/acme/api@refs/heads/main"}},
+	},
+	"runDetails": map[string]any{"builder": map[string]any{"id": "https://github.com/actions/runner/github-hosted"}},
+}
+
+const releaseWorkflow = "https://github.com/acme/api/.github/workflows/release.yml"
+
+func TestFetchProvenance_KeylessReferrers(t *testing.T) {
+	f := newFakeRegistry(t, "")
+	digest := f.putManifest("acme/api", "1.0.0", map[string]any{"mediaType": mediaTypeOCIManifest})
+	other := f.putManifest("acme/api", "0.9.0", map[string]any{"mediaType": mediaTypeOCIManifest, "annotations": map[string]string{"v": "0.9.0"}})
+	fulcio, rekor := newTestFulcio(t), newTestRekor(t)
+	// the certificate expired long ago; the log vouches it was used in time
+	signedAt := time.Now().Add(-70 * time.Minute)
+	priv, cert := fulcio.issue(t, releaseWorkflow+"@refs/heads/main", githubActionsIssuer, signedAt)
+	stmt := statement(digest, slsaProvenanceV1, githubV1)
+
+	bundle := keylessBundle(t, priv, cert, rekor.entry(t, stmt, cert, signedAt.Add(time.Minute)), stmt)
+	att := attestationManifest(f, "acme/api", "application/vnd.dev.sigstore.bundle.v0.3+json", bundle)
+	attDigest := f.putManifest("acme/api", "", att)
+	// a statement about another digest must not count
+	stray := attestationManifest(f, "acme/api", mediaTypeInToto, statement(other, slsaProvenanceV1, githubV1))
+	strayDigest := f.putManifest("acme/api", "", stray)
+	f.referrers = map[string][]any{"acme/api@" + digest: {
+		map[string]any{"mediaType": mediaTypeOCIManifest, "digest": attDigest, "artifactType": "application/vnd.dev.sigstore.bundle.v0.3+json"},
+		map[string]any{"mediaType": mediaTypeOCIManifest, "digest": strayDigest, "artifactType": mediaTypeInToto},
+		map[string]any{"mediaType": mediaTypeOCIManifest, "digest": "sha256:sig", "artifactType": cosignArtifactType},
+	}}
+	// a client per check keeps the registry rate limiter out of the way
+	fetch := func(p ProvenancePolicy, trust ProvenanceTrust) ([]Provenance, error) {
+		return FetchProvenance(context.Background(), NewDistribution(f.srv.URL), "acme/api", digest, p, trust)
+	}
+	p := ProvenancePolicy{Identity: releaseWorkflow}
+	trust := ProvenanceTrust{Fulcio: fulcio.roots, Rekor: rekor.keys}
+
+	provs, err := fetch(p, trust)
+	if err != nil {
+		t.Fatalf("fetch: %v", err)
+	}
+	if len(provs) != 1 || provs[0].BuilderID != "https://github.com/actions/runner/github-hosted" ||
+		provs[0].Source != "github.com/acme/api" {
+		t.Fatalf("unexpected provenance: %+v", provs)
+	}
+
+	wrong := p
+	wrong.Identity = "https://github.com/mallory/api/.github/workflows/release.yml"
+	if _, err := fetch(wrong, trust); err == nil || !strings.Contains(err.Error(), "identity") {
+		t.Fatalf("want an identity mismatch, got %v", err)
+	}
+	wrong = p
+	wrong.Issuer = "https://accounts.google.com"
+	if _, err := fetch(wrong, trust); err == nil || !strings.Contains(err.Error(), "issuer") {
+		t.Fatalf("want an issuer mismatch, got %v", err)
+	}
+	if _, err := fetch(p, ProvenanceTrust{Fulcio: newTestFulcio(t).roots, Rekor: rekor.keys}); err == nil {
+		t.Fatal("a certificate from another CA must not verify")
+	}
+	if _, err := fetch(p, ProvenanceTrust{}); err == nil {
+		t.Fatal("keyless signatures need configured Fulcio roots")
+	}
+}
+
+func TestFetchProvenance_KeylessSigningTime(t *testing.T) {
+	fulcio, rekor := newTestFulcio(t), newTestRekor(t)
+	f := newFakeRegistry(t, "")
+	digest := f.putManifest("acme/api", "1.0.0", map[string]any{"mediaType": mediaTypeOCIManifest})
+	stmt := statement(digest, slsaProvenanceV1, githubV1)
+	identity := releaseWorkflow + "@refs/heads/main"
+	past := time.Now().Add(-70 * time.Minute)
+	expiredKey, expired := fulcio.issue(t, identity, githubActionsIssuer, past)
+	currentKey, current := fulcio.issue(t, identity, githubActionsIssuer, time.Now().Add(-time.Minute))
+
+	forged := rekor.entry(t, stmt, expired, past.Add(time.Minute))
+	forged.integratedTime = time.Now().Unix()
+	elsewhere := rekor.entry(t, statement(digest, slsaProvenanceV0_2, githubV1), expired, past.Add(time.Minute))
+	late := rekor.entry(t, stmt, expired, time.Now())
+
+	cases := []struct {
+		name    string
+		priv    *ecdsa.PrivateKey
+		cert    []byte
+		tlog    *tlogEntry
+		rekor   *RekorKeys
+		wantErr string
+	}{
+		{"logged while valid", expiredKey, expired, rekor.entry(t, stmt, expired, past.Add(time.Minute)), rekor.keys, ""},
+		{"forged integrated time", expiredKey, expired, forged, rekor.keys, "signed entry timestamp"},
+		{"untrusted log", expiredKey, expired, newTestRekor(t).entry(t, stmt, expired, past.Add(time.Minute)), rekor.keys, "not trusted"},
+		{"entry for another statement", expiredKey, expired, elsewhere, rekor.keys, "another statement"},
+		{"logged after expiry", expiredKey, expired, late, rekor.keys, "expired"},
+		{"no entry, expired certificate", expiredKey, expired, nil, rekor.keys, "expired"},
+		{"no rekor keys, expired certificate", expiredKey, expired, rekor.entry(t, stmt, expired, past.Add(time.Minute)), nil, "expired"},
+		{"no entry, valid certificate", currentKey, current, nil, nil, ""},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			att := attestationManifest(f, "acme/api", "application/vnd.dev.sigstore.bundle.v0.3+json", keylessBundle(t, tc.priv, tc.cert, tc.tlog, stmt))
+			f.referrers = map[string][]any{"acme/api@" + digest: {
+				map[string]any{"mediaType": mediaTypeOCIManifest, "digest": f.putManifest("acme/api", "", att), "artifactType": "application/vnd.dev.sigstore.bundle.v0.3+json"},
+			}}
+			_, err := FetchProvenance(context.Background(), NewDistribution(f.srv.URL), "acme/api", digest,
+				ProvenancePolicy{Identity: releaseWorkflow}, ProvenanceTrust{Fulcio: fulcio.roots, Rekor: tc.rekor})
+			switch {
+			case tc.wantErr == "" && err != nil:
+				t.Fatalf("want admitted, got %v", err)
+			case tc.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tc.wantErr)):
+				t.Fatalf("want error containing %q, got %v", tc.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestReadEnvelope_CosignBundleAnnotation(t *testing.T) {
+	fulcio, rekor := newTestFulcio(t), newTestRekor(t)
+	signedAt := time.Now().Add(-70 * time.Minute)
+	priv, cert := fulcio.issue(t, releaseWorkflow+"@refs/heads/main", githubActionsIssuer, signedAt)
+	stmt := statement("sha256:abc", slsaProvenanceV1, githubV1)
+	tlog := rekor.entry(t, stmt, cert, signedAt.Add(time.Minute))
+	annotation, _ := json.Marshal(map[string]any{
+		"SignedEntryTimestamp": tlog.set,
+		"Payload": map[string]any{
+			"body":           base64.StdEncoding.EncodeToString(tlog.body),
+			"integratedTime": tlog.integratedTime,
+			"logIndex":       tlog.logIndex,
+			"logID":          tlog.logID,
+		},
+	})
+	blob, _ := json.Marshal(signedEnvelope(t, priv, stmt))
+
+	env, ok := readEnvelope(mediaTypeDSSE, blob, map[string]string{
+		cosignCertificateAnnotation: string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert})),
+		cosignBundleAnnotation:      string(annotation),
+	})
+	if !ok || env.tlog == nil || len(env.certs) != 1 {
+		t.Fatalf("unexpected envelope: %+v", env)
+	}
+	at, err := ProvenanceTrust{Fulcio: fulcio.roots, Rekor: rekor.keys}.signedAt(env)
+	if err != nil || !at.Equal(time.Unix(tlog.integratedTime, 0)) {
+		t.Fatalf("signedAt = %v, %v", at, err)
+	}
+}
+
+func TestFetchProvenance_RejectsUnsignedAndForged(t *testing.T) {
+	f := newFakeRegistry(t, "")
+	digest := f.putManifest("acme/api", "1.0.0", map[string]any{"mediaType": mediaTypeOCIManifest})
+	priv, key := newCosignKey(t)
+	forger, _ := newCosignKey(t)
+	stmt := statement(digest, slsaProvenanceV1, githubV1)
+
+	bare := attestationManifest(f, "acme/api", mediaTypeInToto, stmt)
+	unsigned, _ := json.Marshal(signedEnvelope(t, nil, stmt))
+	forged, _ := json.Marshal(signedEnvelope(t, forger, stmt))
+	var refs []any
+	for _, m := range []map[string]any{bare, attestationManifest(f, "acme/api", mediaTypeDSSE, unsigned), attestationManifest(f, "acme/api", mediaTypeDSSE, forged)} {
+		refs = append(refs, map[string]any{"mediaType": mediaTypeOCIManifest, "digest": f.putManifest("acme/api", "", m), "artifactType": mediaTypeDSSE})
+	}
+	f.referrers = map[string][]any{"acme/api@" + digest: refs}
+	d := NewDistribution(f.srv.URL)
+	p := ProvenancePolicy{Source: "github.com/acme/api"}
+
+	err := VerifyProvenance(context.Background(), d, "acme/api", digest, p, ProvenanceTrust{Key: key})
+	if err == nil || errors.Is(err, ErrNoProvenance) || !strings.Contains(err.Error(), "3 provenance") {
+		t.Fatalf("want all three attestations rejected, got %v", err)
+	}
+
+	signed, _ := json.Marshal(signedEnvelope(t, priv, stmt))
+	f.putManifest("acme/api", "sha256-"+strings.TrimPrefix(digest, "sha256:")+".att", attestationManifest(f, "acme/api", mediaTypeDSSE, signed))
+	if err := VerifyProvenance(context.Background(), d, "acme/api", digest, p, ProvenanceTrust{Key: key}); err != nil {
+		t.Fatalf("a signed attestation among forged ones should admit: %v", err)
+	}
+}
+
+func TestVerifyProvenance_AttTag(t *testing.T) {
+	f := newFakeRegistry(t, "")
+	digest := f.putManifest("acme/api", "1.0.0", map[string]any{"mediaType": mediaTypeOCIManifest})
+	d := NewDistribution(f.srv.URL)
+	priv, key := newCosignKey(t)
+	trust := ProvenanceTrust{Key: key}
+	want := ProvenancePolicy{
+		Builder: "https://github.com/slsa-framework/slsa-github-generator/.github/workflows/generator_container_slsa3.yml",
+		Source:  "https://github.com/Acme/api",
+	}
+
+	if err := VerifyProvenance(context.Background(), d, "acme/api", digest, want, trust); !errors.Is(err, ErrNoProvenance) {
+		t.Fatalf("want ErrNoProvenance, got %v", err)
+	}
+
+	env, _ := json.Marshal(signedEnvelope(t, priv, statement(digest, slsaProvenanceV0_2, map[string]any{
+		"builder":    map[string]any{"id": want.Builder + "@refs/tags/v1.9.0"},
+		"invocation": map[string]any{"configSource": map[string]any{"uri": "git+https://github.com/acme/api.git@refs/tags/v1.0.0"}},
+		"materials":  []any{map[string]any{"uri": "git+https://github.com/acme/web@refs/heads/main"}},
+	})))
+	f.putManifest("acme/api", "sha256-"+strings.TrimPrefix(digest, "sha256:")+".att", attestationManifest(f, "acme/api", mediaTypeDSSE, env))
+
+	if err := VerifyProvenance(context.Background(), d, "acme/api", digest, want, trust); err != nil {
+		t.Fatalf("verify: %v", err)
+	}
+	wrong := want
+	wrong.Source = "github.com/acme/web" // a material, not the build's source
+	if err := VerifyProvenance(context.Background(), d, "acme/api", digest, wrong, trust); err == nil || errors.Is(err, ErrNoProvenance) {
+		t.Fatalf("want a source mismatch, got %v", err)
+	}
+	wrong = want
+	wrong.Builder = "https://github.com/actions/runner/github-hosted"
+	if err := VerifyProvenance(context.Background(), d, "acme/api", digest, wrong, trust); err == nil {
+		t.Fatal("want a builder mismatch")
+	}
+	if err := VerifyProvenance(context.Background(), d, "acme/api", digest, want, ProvenanceTrust{}); err == nil || !strings.Contains(err.Error(), "MD_COSIGN_KEYS") {
+		t.Fatalf("without a key or identity nothing is trusted, got %v", err)
+	}
+}
@@ -0,0 +1,218 @@
+package watcher
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+)
+
+const (
+	// cosignPayloadType is the layer media type of a cosign simple-signing
+	// payload; its signature travels in the cosignSignatureAnnotation.
+	cosignPayloadType         = "application/vnd.dev.cosign.simplesigning.v1+json"
+	cosignSignatureAnnotation = "dev.cosignproject.cosign/signature"
+	// cosignArtifactType marks signatures attached as OCI 1.1 referrers.
+	cosignArtifactType = "application/vnd.dev.cosign.artifact.sig.v1+json"
+)
+
+// ErrUnsigned is returned by verifyCosign when no signature for the digest
+// exists in the registry.
+var ErrUnsigned = errors.New("no cosign signature found")
+
+// CosignKey is a public key images are verified against.
+type CosignKey struct {
+	Path string // where it was loaded from, for logs and state
+	pub  crypto.PublicKey
+}
+
+// ParseCosignKey reads a PEM public key as written by "cosign generate-key-pair"
+// (ECDSA P-256), or an Ed25519 or RSA key.
+func ParseCosignKey(path string, data []byte) (*CosignKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("cosign key %s: no PEM block", path)
+	}
+	pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("cosign key %s: %w", path, err)
+	}
+	switch pub.(type) {
+	case *ecdsa.PublicKey, ed25519.PublicKey, *rsa.PublicKey:
+	default:
+		return nil, fmt.Errorf("cosign key %s: unsupported key type %T", path, pub)
+	}
+	return &CosignKey{Path: path, pub: pub}, nil
+}
+
+// verify checks sig over payload.
+func (k *CosignKey) verify(payload, sig []byte) bool {
+	sum := sha256.Sum256(payload)
+	switch pub := k.pub.(type) {
+	case *ecdsa.PublicKey:
+		return ecdsa.VerifyASN1(pub, sum[:], sig)
+	case ed25519.PublicKey:
+		return ed25519.Verify(pub, payload, sig)
+	case *rsa.PublicKey:
+		return rsa.VerifyPKCS1v15(pub, crypto.SHA256, sum[:], sig) == nil
+	}
+	return false
+}
+
+// CosignKeys maps image prefixes ("ghcr.io/acme", "ghcr.io/acme/api") to
+// the key their images must be signed with; the longest prefix wins.
+type CosignKeys struct {
+	prefixes []string // longest first
+	keys     map[string]*CosignKey
+}
+
+// NewCosignKeys builds the table from prefix -> key.
+func NewCosignKeys(keys map[string]*CosignKey) *CosignKeys {
+	k := &CosignKeys{keys: make(map[string]*CosignKey, len(keys))}
+	for prefix, key := range keys {
+		prefix = normalizeImagePrefix(prefix)
+		k.keys[prefix] = key
+		k.prefixes = append(k.prefixes, prefix)
+	}
+	sort.Slice(k.prefixes, func(i, j int) bool { return len(k.prefixes[i]) > len(k.prefixes[j]) })
+	return k
+}
+
+// LoadCosignKeys reads MD_COSIGN_KEYS, e.g.
+// "ghcr.io/acme=/keys/acme.pub,docker.io/library/redis=/keys/redis.pub".
+// Unreadable entries are logged and left out, which leaves their images
+// unverified rather than blocked.
+func LoadCosignKeys() *CosignKeys {
+	keys := make(map[string]*CosignKey)
+	for _, entry := range strings.Split(os.Getenv("MD_COSIGN_KEYS"), ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		prefix, path, ok := strings.Cut(entry, "=")
+		if !ok {
+			log.Printf("[cosign] ignoring bad key entry %q", entry)
+			continue
+		}
+		data, err := os.ReadFile(strings.TrimSpace(path))
+		if err != nil {
+			log.Printf("[cosign] ignoring key for %s: %v", prefix, err)
+			continue
+		}
+		key, err := ParseCosignKey(strings.TrimSpace(path), data)
+		if err != nil {
+			log.Printf("[cosign] ignoring key for %s: %v", prefix, err)
+			continue
+		}
+		keys[prefix] = key
+	}
+	return NewCosignKeys(keys)
+}
+
+// For returns the key for an image ("ghcr.io/acme/api"), or nil when its
+// images are not verified.
+func (k *CosignKeys) For(image string) *CosignKey {
+	if k == nil {
+		return nil
+	}
+	image = normalizeImagePrefix(image)
+	for _, p := range k.prefixes {
+		if image == p || strings.HasPrefix(image, p+"/") {
+			return k.keys[p]
+		}
+	}
+	return nil
+}
+
+func normalizeImagePrefix(s string) string {
+	// a leading "/" is an empty (Docker Hub) registry
+	s = strings.ToLower(strings.TrimRight(strings.TrimSpace(s), "/"))
+	host, rest, _ := strings.Cut(s, "/")
+	if rest == "" {
+		return canonicalHost(host)
+	}
+	return canonicalHost(host) + "/" + rest
+}
+
+// verifyCosign checks that digest of repo carries a cosign signature made
+// with key, looking at the "sha256-<hex>.sig" tag first and then at OCI 1.1
+// referrers when the registry supports them.
+func verifyCosign(ctx context.Context, r Registry, repo, digest string, key *CosignKey) error {
+	var sigs []*Manifest
+	m, err := r.Manifest(ctx, repo, strings.Replace(digest, ":", "-", 1)+".sig")
+	switch {
+	case err == nil:
+		sigs = append(sigs, m)
+	case !errors.Is(err, os.ErrNotExist):
+		return fmt.Errorf("signature manifest: %w", err)
+	}
+
+	if lr, ok := r.(interface {
+		Referrers(ctx context.Context, repo, digest, artifactType string) ([]Descriptor, error)
+	}); ok {
+		refs, err := lr.Referrers(ctx, repo, digest, cosignArtifactType)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("referrers: %w", err)
+		}
+		for _, desc := range refs {
+			m, err := r.Manifest(ctx, repo, desc.Digest)
+			if err != nil {
+				return fmt.Errorf("referrer %s: %w", desc.Digest, err)
+			}
+			sigs = append(sigs, m)
+		}
+	}
+
+	found := 0
+	for _, m := range sigs {
+		for _, layer := range m.Layers {
+			b64, ok := layer.Annotations[cosignSignatureAnnotation]
+			if !ok || layer.MediaType != cosignPayloadType {
+				continue
+			}
+			found++
+			sig, err := base64.StdEncoding.DecodeString(b64)
+			if err != nil {
+				continue
+			}
+			payload, err := r.Blob(ctx, repo, layer.Digest)
+			if err != nil {
+				return fmt.Errorf("signature payload: %w", err)
+			}
+			if signedDigest(payload) == digest && key.verify(payload, sig) {
+				return nil
+			}
+		}
+	}
+	if found == 0 {
+		return ErrUnsigned
+	}
+	return fmt.Errorf("none of %d signature(s) verify with %s", found, key.Path)
+}
+
+// signedDigest is the manifest digest a simple-signing payload vouches for.
+func signedDigest(payload []byte) string {
+	var p struct {
+		Critical struct {
+			Image struct {
+				Digest string `json:"docker-manifest-digest"`
+			} `json:"image"`
+		} `json:"critical"`
+	}
+	if err := json.Unmarshal(payload, &p); err != nil {
+		return ""
+	}
+	return p.Critical.Image.Digest
+}
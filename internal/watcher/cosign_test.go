@@ -0,0 +1,166 @@
+package watcher
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/jpvargasdev/magos-dominus/internal/events"
+	"github.com/jpvargasdev/magos-dominus/internal/state"
+)
+
+func newCosignKey(t *testing.T) (*ecdsa.PrivateKey, *CosignKey) {
+	t.Helper()
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(&priv.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ParseCosignKey("cosign.pub", pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return priv, key
+}
+
+// signManifest builds a cosign signature manifest for digest and returns it
+// with its payload blob stored in f.
+func signManifest(t *testing.T, f *fakeRegistry, repo, digest string, priv *ecdsa.PrivateKey) map[string]any {
+	t.Helper()
+	payload, _ := json.Marshal(map[string]any{
+		"critical": map[string]any{
+			"identity": map[string]any{"docker-reference": repo},
+			"image":    map[string]any{"docker-manifest-digest": digest},
+			"type":     "cosign container image signature",
+		},
+	})
+	sum := sha256.Sum256(payload)
+	sig, err := ecdsa.SignASN1(rand.Reader, priv, sum[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	pd := sha256Digest(payload)
+	f.mu.Lock()
+	f.blobs[repo+"@"+pd] = payload
+	f.mu.Unlock()
+	return map[string]any{
+		"mediaType": mediaTypeOCIManifest,
+		"config":    map[string]any{"mediaType": "application/vnd.oci.image.config.v1+json", "digest": pd, "size": len(payload)},
+		"layers": []any{map[string]any{
+			"mediaType":   cosignPayloadType,
+			"digest":      pd,
+			"size":        len(payload),
+			"annotations": map[string]string{cosignSignatureAnnotation: base64.StdEncoding.EncodeToString(sig)},
+		}},
+	}
+}
+
+func TestVerifyCosign_SigTag(t *testing.T) {
+	f := newFakeRegistry(t, "")
+	priv, key := newCosignKey(t)
+	_, otherKey := newCosignKey(t)
+	digest := f.putManifest("team/app", "1.0.0", map[string]any{"mediaType": mediaTypeOCIManifest})
+	d := NewDistribution(f.srv.URL)
+
+	if err := verifyCosign(context.Background(), d, "team/app", digest, key); !errors.Is(err, ErrUnsigned) {
+		t.Fatalf("want ErrUnsigned, got %v", err)
+	}
+
+	f.putManifest("team/app", "sha256-"+digest[len("sha256:"):]+".sig", signManifest(t, f, "team/app", digest, priv))
+	if err := verifyCosign(context.Background(), d, "team/app", digest, key); err != nil {
+		t.Fatalf("verify: %v", err)
+	}
+	if err := verifyCosign(context.Background(), d, "team/app", digest, otherKey); err == nil || errors.Is(err, ErrUnsigned) {
+		t.Fatalf("want a verification failure with the wrong key, got %v", err)
+	}
+}
+
+func TestVerifyCosign_Referrers(t *testing.T) {
+	f := newFakeRegistry(t, "")
+	priv, key := newCosignKey(t)
+	digest := f.putManifest("team/app", "1.0.0", map[string]any{"mediaType": mediaTypeOCIManifest})
+
+	sig := signManifest(t, f, "team/app", digest, priv)
+	sig["artifactType"] = cosignArtifactType
+	sigDigest := f.putManifest("team/app", "", sig)
+	f.referrers = map[string][]any{"team/app@" + digest: {
+		map[string]any{"mediaType": mediaTypeOCIManifest, "digest": sigDigest, "artifactType": cosignArtifactType},
+		map[string]any{"mediaType": mediaTypeOCIManifest, "digest": "sha256:sbom", "artifactType": "application/spdx+json"},
+	}}
+
+	if err := verifyCosign(context.Background(), NewDistribution(f.srv.URL), "team/app", digest, key); err != nil {
+		t.Fatalf("verify via referrers: %v", err)
+	}
+}
+
+func TestCheck_HoldsUnsignedDigest(t *testing.T) {
+	f := newFakeRegistry(t, "")
+	host := mustHost(t, f.srv.URL)
+	priv, key := newCosignKey(t)
+	digest := f.putManifest("team/app", "1.0.0", map[string]any{"mediaType": mediaTypeOCIManifest})
+
+	st := state.New(filepath.Join(t.TempDir(), "state.json"))
+	tg := Target{Name: "/git/app/compose.yml", Image: ImageRef{Registry: host, Owner: "team", Name: "app", Tag: "1.0.0"}, Policy: "digest"}
+	st.UpsertDigest(stateKey(tg), "sha256:old", "", tg.Policy)
+
+	em := make(events.ChanEmitter, 1)
+	w := NewFromConfig(WatcherConfig{
+		Targets:    []Target{tg},
+		CosignKeys: NewCosignKeys(map[string]*CosignKey{host + "/team": key}),
+	}, em)
+	regs := NewRegistriesWithKeychain(nil)
+
+	if err := w.check(context.Background(), regs, st, tg); err != nil {
+		t.Fatalf("check: %v", err)
+	}
+	e, _ := st.Get(stateKey(tg))
+	if len(em) != 0 || e.Digest != "sha256:old" || e.Verification != nil {
+		t.Fatalf("unsigned digest must not be adopted (events %d, entry %+v)", len(em), e)
+	}
+	c, _ := st.LastCheck(stateKey(tg))
+	if v := c.Verification; v == nil || v.Verified || v.Digest != digest {
+		t.Fatalf("unexpected verification: %+v", v)
+	}
+
+	f.putManifest("team/app", "sha256-"+digest[len("sha256:"):]+".sig", signManifest(t, f, "team/app", digest, priv))
+	if err := w.check(context.Background(), regs, st, tg); err != nil {
+		t.Fatalf("check: %v", err)
+	}
+	e, _ = st.Get(stateKey(tg))
+	c, _ = st.LastCheck(stateKey(tg))
+	if len(em) != 1 || e.Digest != digest || !c.Verification.Verified {
+		t.Fatalf("signed digest should be adopted (events %d, entry %+v)", len(em), e)
+	}
+	if v := e.Verification; v == nil || !v.Verified || v.Digest != digest {
+		t.Fatalf("the entry should carry the verification that admitted it: %+v", v)
+	}
+}
+
+func TestCosignKeys_LongestPrefix(t *testing.T) {
+	_, org := newCosignKey(t)
+	_, api := newCosignKey(t)
+	keys := NewCosignKeys(map[string]*CosignKey{"ghcr.io/acme": org, "GHCR.io/acme/api/": api, "docker.io/library/redis": org})
+	for image, want := range map[string]*CosignKey{
+		"ghcr.io/acme/api":              api,
+		"ghcr.io/acme/web":              org,
+		"ghcr.io/acme-labs/web":         nil,
+		"index.docker.io/library/redis": org,
+		"/library/redis":                org,
+	} {
+		if got := keys.For(image); got != want {
+			t.Errorf("For(%q) = %v, want %v", image, got, want)
+		}
+	}
+}
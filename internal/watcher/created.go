@@ -0,0 +1,72 @@
+package watcher
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// labelCreated is the OCI annotation/label CI pipelines set to the build time.
+const labelCreated = "org.opencontainers.image.created"
+
+// imageCreated reports when the image behind ref was built. For an index the
+// image for p is used (the host platform when p is nil). The OCI "created"
+// label or annotation wins over the config's created field, which is often
+// zeroed or pinned to the epoch by reproducible builds.
+func imageCreated(ctx context.Context, r Registry, repo, ref string, p *Platform) (time.Time, error) {
+	m, err := r.Manifest(ctx, repo, ref)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("manifest: %w", err)
+	}
+	if m.IsIndex() {
+		want := hostPlatform()
+		if p != nil {
+			want = *p
+		}
+		desc, err := m.forPlatform(want)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if m, err = r.Manifest(ctx, repo, desc.Digest); err != nil {
+			return time.Time{}, fmt.Errorf("manifest: %w", err)
+		}
+	}
+	if t, ok := parseCreated(m.Annotations[labelCreated]); ok {
+		return t, nil
+	}
+
+	raw, err := r.Blob(ctx, repo, m.Config.Digest)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("config blob: %w", err)
+	}
+	var cfg struct {
+		Created string `json:"created"`
+		Config  struct {
+			Labels map[string]string `json:"Labels"`
+		} `json:"config"`
+	}
+	if err := json.Unmarshal(raw, &cfg); err != nil {
+		return time.Time{}, fmt.Errorf("decode config: %w", err)
+	}
+	if t, ok := parseCreated(cfg.Config.Labels[labelCreated]); ok {
+		return t, nil
+	}
+	if t, ok := parseCreated(cfg.Created); ok {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("image %s has no creation time", m.Digest)
+}
+
+// parseCreated accepts RFC 3339 timestamps and ignores the zero/epoch values
+// reproducible builds write.
+func parseCreated(s string) (time.Time, bool) {
+	if s == "" {
+		return time.Time{}, false
+	}
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil || t.Unix() <= 0 {
+		return time.Time{}, false
+	}
+	return t, true
+}
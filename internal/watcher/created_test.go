@@ -0,0 +1,115 @@
+package watcher
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jpvargasdev/magos-dominus/internal/events"
+	"github.com/jpvargasdev/magos-dominus/internal/state"
+)
+
+// image stores a single-platform manifest whose config has the given created
+// time and labels, and returns the manifest digest.
+func (f *fakeRegistry) image(repo, ref string, created time.Time, labels map[string]string) string {
+	cfg := map[string]any{
+		"architecture": "amd64",
+		"os":           "linux",
+		"config":       map[string]any{"Labels": labels},
+	}
+	if !created.IsZero() {
+		cfg["created"] = created.UTC().Format(time.RFC3339Nano)
+	}
+	return f.putManifest(repo, ref, map[string]any{
+		"schemaVersion": 2,
+		"mediaType":     mediaTypeOCIManifest,
+		"config":        f.putBlob(repo, cfg),
+		"layers":        []any{},
+	})
+}
+
+func TestImageCreated(t *testing.T) {
+	f := newFakeRegistry(t, "bearer")
+	reg, err := NewRegistriesWithKeychain(nil).For(mustHost(t, f.srv.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	built := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	f.image("team/app", "plain", built, nil)
+	if got, err := imageCreated(ctx, reg, "team/app", "plain", nil); err != nil || !got.Equal(built) {
+		t.Fatalf("config created: got %v, %v", got, err)
+	}
+
+	// reproducible builds zero "created"; the OCI label still carries the date
+	f.image("team/app", "repro", time.Unix(0, 0), map[string]string{labelCreated: "2025-03-02T08:00:00Z"})
+	got, err := imageCreated(ctx, reg, "team/app", "repro", nil)
+	if err != nil || !got.Equal(built.Add(20*time.Hour)) {
+		t.Fatalf("label: got %v, %v", got, err)
+	}
+
+	f.image("team/app", "unknown", time.Unix(0, 0), nil)
+	if _, err := imageCreated(ctx, reg, "team/app", "unknown", nil); err == nil {
+		t.Fatalf("expected error for an image without a creation time")
+	}
+
+	// an index resolves to the requested platform's image
+	amd := f.image("team/app", "", built, nil)
+	arm := f.image("team/app", "", built.Add(time.Hour), nil)
+	f.putManifest("team/app", "multi", index(amd, arm))
+	p := Platform{OS: "linux", Architecture: "arm64"}
+	if got, err := imageCreated(ctx, reg, "team/app", "multi", &p); err != nil || !got.Equal(built.Add(time.Hour)) {
+		t.Fatalf("index: got %v, %v", got, err)
+	}
+}
+
+func TestCheck_MinAgeHoldsYoungImages(t *testing.T) {
+	f := newFakeRegistry(t, "")
+	host := mustHost(t, f.srv.URL)
+	f.image("team/app", "1.0.0", time.Now().Add(-100*time.Hour), nil)
+
+	tg := Target{
+		Name:   "/git/app/compose.yml",
+		Image:  ImageRef{Registry: host, Owner: "team", Name: "app", Tag: "1.0.0"},
+		Policy: "digest",
+		MinAge: 72 * time.Hour,
+	}
+	st := state.New(filepath.Join(t.TempDir(), "state.json"))
+	em := make(events.ChanEmitter, 8)
+	w := New([]Target{tg}, em)
+	regs := NewRegistriesWithKeychain(nil)
+	ctx := context.Background()
+
+	if err := w.check(ctx, regs, st, tg); err != nil {
+		t.Fatalf("seed check: %v", err)
+	}
+	seeded, _ := st.Get(stateKey(tg))
+
+	// a rebuild from an hour ago is held and stays pending
+	young := f.image("team/app", "1.0.0", time.Now().Add(-time.Hour), nil)
+	for i := 0; i < 2; i++ {
+		if err := w.check(ctx, regs, st, tg); err != nil {
+			t.Fatalf("check: %v", err)
+		}
+	}
+	if len(em) != 0 {
+		t.Fatalf("young image must not be adopted")
+	}
+	if e, _ := st.Get(stateKey(tg)); e.Digest != seeded.Digest {
+		t.Fatalf("state moved to %s while held", e.Digest)
+	}
+
+	// once old enough (here: a different build that is), it is adopted
+	old := f.image("team/app", "1.0.0", time.Now().Add(-80*time.Hour), nil)
+	if err := w.check(ctx, regs, st, tg); err != nil {
+		t.Fatalf("check: %v", err)
+	}
+	if len(em) != 1 {
+		t.Fatalf("expected one event, got %d", len(em))
+	}
+	if ev := <-em; ev.Digest != old || ev.Digest == young {
+		t.Fatalf("event digest = %s, want %s", ev.Digest, old)
+	}
+}
@@ -0,0 +1,195 @@
+package watcher
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// credentialHelperTimeout bounds one docker-credential-* run; a helper that
+// waits on a locked keyring must not hold up registry checks.
+const credentialHelperTimeout = 10 * time.Second
+
+// Credentials for one registry host, as written by `docker login` or
+// `podman login`. IdentityToken is an OAuth2 refresh token and, when set, is
+// exchanged at the token realm instead of sending a password.
+type Credentials struct {
+	Username      string
+	Password      string
+	IdentityToken string
+}
+
+func (c Credentials) empty() bool {
+	return c.Username == "" && c.Password == "" && c.IdentityToken == ""
+}
+
+// Keychain looks up registry credentials the way podman and docker do: the
+// first auth file that knows a host wins, and per-host credHelpers or a global
+// credsStore are queried through the docker-credential-* exec protocol.
+type Keychain struct {
+	paths []string
+}
+
+// DefaultKeychain searches, in order: $REGISTRY_AUTH_FILE,
+// $XDG_RUNTIME_DIR/containers/auth.json, ~/.config/containers/auth.json,
+// $DOCKER_CONFIG/config.json and ~/.docker/config.json.
+func DefaultKeychain() *Keychain {
+	var paths []string
+	if p := os.Getenv("REGISTRY_AUTH_FILE"); p != "" {
+		paths = append(paths, p)
+	}
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		paths = append(paths, filepath.Join(dir, "containers", "auth.json"))
+	}
+	cfgHome := os.Getenv("XDG_CONFIG_HOME")
+	home, _ := os.UserHomeDir()
+	if cfgHome == "" && home != "" {
+		cfgHome = filepath.Join(home, ".config")
+	}
+	if cfgHome != "" {
+		paths = append(paths, filepath.Join(cfgHome, "containers", "auth.json"))
+	}
+	if dir := os.Getenv("DOCKER_CONFIG"); dir != "" {
+		paths = append(paths, filepath.Join(dir, "config.json"))
+	} else if home != "" {
+		paths = append(paths, filepath.Join(home, ".docker", "config.json"))
+	}
+	return NewKeychain(paths...)
+}
+
+// NewKeychain builds a Keychain over explicit auth file paths.
+func NewKeychain(paths ...string) *Keychain {
+	return &Keychain{paths: paths}
+}
+
+type authFile struct {
+	Auths map[string]struct {
+		Auth          string `json:"auth"`
+		Username      string `json:"username"`
+		Password      string `json:"password"`
+		IdentityToken string `json:"identitytoken"`
+	} `json:"auths"`
+	CredHelpers map[string]string `json:"credHelpers"`
+	CredsStore  string            `json:"credsStore"`
+}
+
+// Resolve returns credentials for a registry host. ok is false when no auth
+// file has anything for it, in which case anonymous access is used.
+func (k *Keychain) Resolve(host string) (Credentials, bool, error) {
+	host = canonicalHost(host)
+
+	for _, p := range k.paths {
+		data, err := os.ReadFile(p)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return Credentials{}, false, fmt.Errorf("read %s: %w", p, err)
+		}
+		var f authFile
+		if err := json.Unmarshal(data, &f); err != nil {
+			return Credentials{}, false, fmt.Errorf("parse %s: %w", p, err)
+		}
+
+		for key, helper := range f.CredHelpers {
+			if authKeyHost(key) == host {
+				return credentialHelper(helper, serverURL(host))
+			}
+		}
+		for key, a := range f.Auths {
+			if authKeyHost(key) != host {
+				continue
+			}
+			c := Credentials{Username: a.Username, Password: a.Password, IdentityToken: a.IdentityToken}
+			if a.Auth != "" {
+				raw, err := base64.StdEncoding.DecodeString(a.Auth)
+				if err != nil {
+					return Credentials{}, false, fmt.Errorf("%s: bad auth for %s: %w", p, key, err)
+				}
+				user, pass, ok := strings.Cut(string(raw), ":")
+				if !ok {
+					return Credentials{}, false, fmt.Errorf("%s: auth for %s is not user:password", p, key)
+				}
+				c.Username, c.Password = user, pass
+			}
+			if !c.empty() {
+				return c, true, nil
+			}
+		}
+		if f.CredsStore != "" {
+			c, ok, err := credentialHelper(f.CredsStore, serverURL(host))
+			if err != nil || ok {
+				return c, ok, err
+			}
+		}
+	}
+	return Credentials{}, false, nil
+}
+
+// authKeyHost reduces an auths/credHelpers key such as
+// "https://index.docker.io/v1/" or "ghcr.io" to a canonical host.
+func authKeyHost(key string) string {
+	if strings.Contains(key, "://") {
+		if u, err := url.Parse(key); err == nil {
+			key = u.Host
+		}
+	}
+	key, _, _ = strings.Cut(key, "/")
+	return canonicalHost(key)
+}
+
+// serverURL is what credential helpers were given at login time; Docker Hub
+// is stored under its legacy v1 index URL.
+func serverURL(host string) string {
+	if host == dockerHubHost {
+		return "https://index.docker.io/v1/"
+	}
+	return host
+}
+
+// credentialHelper runs `docker-credential-<name> get` with the server URL on
+// stdin. Helpers report an unknown host with a non-zero exit and
+// "credentials not found in native keychain" on stdout.
+func credentialHelper(name, server string) (Credentials, bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), credentialHelperTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "docker-credential-"+name, "get")
+	cmd.Stdin = strings.NewReader(server)
+	var out, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &out, &stderr
+
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return Credentials{}, false, fmt.Errorf("docker-credential-%s: timeout", name)
+		}
+		if strings.Contains(strings.ToLower(out.String()), "credentials not found") {
+			return Credentials{}, false, nil
+		}
+		return Credentials{}, false, fmt.Errorf("docker-credential-%s: %v: %s", name, err, strings.TrimSpace(stderr.String()+out.String()))
+	}
+
+	var resp struct {
+		ServerURL string `json:"ServerURL"`
+		Username  string `json:"Username"`
+		Secret    string `json:"Secret"`
+	}
+	if err := json.Unmarshal(out.Bytes(), &resp); err != nil {
+		return Credentials{}, false, fmt.Errorf("docker-credential-%s: decode: %w", name, err)
+	}
+	// "<token>" is the helper convention for an identity (refresh) token.
+	if resp.Username == "<token>" {
+		return Credentials{IdentityToken: resp.Secret}, true, nil
+	}
+	c := Credentials{Username: resp.Username, Password: resp.Secret}
+	return c, !c.empty(), nil
+}
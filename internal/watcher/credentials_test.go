@@ -0,0 +1,239 @@
+package watcher
+
+import (
+	"context"
+	"encoding/base64"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"testing"
+)
+
+func writeAuthFile(t *testing.T, dir, name, content string) string {
+	t.Helper()
+	p := filepath.Join(dir, name)
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	if err := os.WriteFile(p, []byte(content), 0o600); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	return p
+}
+
+func b64(s string) string { return base64.StdEncoding.EncodeToString([]byte(s)) }
+
+func TestKeychain_AuthsEntries(t *testing.T) {
+	dir := t.TempDir()
+	p := writeAuthFile(t, dir, "config.json", `{
+  "auths": {
+    "ghcr.io": {"auth": "`+b64("octo:ghp_secret")+`"},
+    "https://index.docker.io/v1/": {"auth": "`+b64("hubuser:hubpass")+`"},
+    "quay.io": {"identitytoken": "refresh-me"}
+  }
+}`)
+	k := NewKeychain(p)
+
+	c, ok, err := k.Resolve("ghcr.io")
+	if err != nil || !ok {
+		t.Fatalf("ghcr.io: ok=%v err=%v", ok, err)
+	}
+	if c.Username != "octo" || c.Password != "ghp_secret" {
+		t.Fatalf("ghcr.io creds = %+v", c)
+	}
+
+	c, ok, _ = k.Resolve("docker.io")
+	if !ok || c.Username != "hubuser" {
+		t.Fatalf("docker.io should match the v1 index key, got ok=%v %+v", ok, c)
+	}
+
+	c, ok, _ = k.Resolve("quay.io")
+	if !ok || c.IdentityToken != "refresh-me" {
+		t.Fatalf("quay.io identity token = %+v", c)
+	}
+
+	if _, ok, _ := k.Resolve("registry.lan:5000"); ok {
+		t.Fatalf("unknown host should resolve to anonymous")
+	}
+}
+
+func TestKeychain_FirstFileWins(t *testing.T) {
+	dir := t.TempDir()
+	podman := writeAuthFile(t, dir, "containers/auth.json",
+		`{"auths":{"ghcr.io":{"auth":"`+b64("podman:one")+`"}}}`)
+	docker := writeAuthFile(t, dir, "docker/config.json",
+		`{"auths":{"ghcr.io":{"auth":"`+b64("docker:two")+`"},"quay.io":{"auth":"`+b64("docker:three")+`"}}}`)
+	k := NewKeychain(podman, filepath.Join(dir, "missing.json"), docker)
+
+	if c, _, _ := k.Resolve("ghcr.io"); c.Username != "podman" {
+		t.Fatalf("expected podman auth file to win, got %+v", c)
+	}
+	if c, _, _ := k.Resolve("quay.io"); c.Username != "docker" {
+		t.Fatalf("expected fallthrough to docker config, got %+v", c)
+	}
+}
+
+func TestKeychain_CredentialHelper(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("shell helper")
+	}
+	dir := t.TempDir()
+	helper := writeAuthFile(t, dir, "bin/docker-credential-fake", `#!/bin/sh
+read server
+if [ "$server" = "ghcr.io" ]; then
+  echo '{"ServerURL":"ghcr.io","Username":"helper-user","Secret":"helper-pass"}'
+  exit 0
+fi
+echo "credentials not found in native keychain"
+exit 1
+`)
+	if err := os.Chmod(helper, 0o755); err != nil {
+		t.Fatalf("chmod: %v", err)
+	}
+	t.Setenv("PATH", filepath.Dir(helper)+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	p := writeAuthFile(t, dir, "config.json", `{"credHelpers":{"ghcr.io":"fake"},"credsStore":"fake"}`)
+	k := NewKeychain(p)
+
+	c, ok, err := k.Resolve("ghcr.io")
+	if err != nil || !ok {
+		t.Fatalf("helper: ok=%v err=%v", ok, err)
+	}
+	if c.Username != "helper-user" || c.Password != "helper-pass" {
+		t.Fatalf("helper creds = %+v", c)
+	}
+
+	// credsStore is consulted for other hosts; "not found" means anonymous.
+	if _, ok, err := k.Resolve("quay.io"); ok || err != nil {
+		t.Fatalf("expected not-found from credsStore, got ok=%v err=%v", ok, err)
+	}
+}
+
+// helperRegistry serves a private repository whose credentials come from a
+// docker-credential-test helper running script; calls counts its runs.
+func helperRegistry(t *testing.T, script string) (f *fakeRegistry, regs *Registries, calls string) {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("shell helper")
+	}
+	f = newFakeRegistry(t, "bearer")
+	f.user, f.pass = "u", "p"
+	f.digests["team/private:1.0.0"] = "sha256:ccc"
+
+	dir := t.TempDir()
+	calls = filepath.Join(dir, "calls")
+	helper := writeAuthFile(t, dir, "bin/docker-credential-test", "#!/bin/sh\necho x >> \""+calls+"\"\n"+script)
+	if err := os.Chmod(helper, 0o755); err != nil {
+		t.Fatalf("chmod: %v", err)
+	}
+	t.Setenv("PATH", filepath.Dir(helper)+string(os.PathListSeparator)+os.Getenv("PATH"))
+	p := writeAuthFile(t, dir, "config.json", `{"credHelpers":{"`+mustHost(t, f.srv.URL)+`":"test"}}`)
+	return f, NewRegistriesWithKeychain(NewKeychain(p)), calls
+}
+
+func helperRuns(t *testing.T, calls string) int {
+	data, _ := os.ReadFile(calls)
+	return len(data) / 2
+}
+
+func TestRegistries_ResolvesCredentialsOncePerHost(t *testing.T) {
+	f, regs, calls := helperRegistry(t, `sleep 0.2
+echo '{"Username":"u","Secret":"p"}'
+`)
+	host := mustHost(t, f.srv.URL)
+	reg, err := regs.For(host)
+	if err != nil {
+		t.Fatalf("For: %v", err)
+	}
+	if again, _ := regs.For(host); again != reg {
+		t.Fatal("callers for one host got different clients")
+	}
+	if n := helperRuns(t, calls); n != 0 {
+		t.Fatalf("helper ran %d times before any request", n)
+	}
+
+	var wg sync.WaitGroup
+	errs := make([]error, 4)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, _, _, errs[i] = reg.HeadDigest(context.Background(), "team/private", "1.0.0", "")
+		}(i)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			t.Fatalf("HeadDigest: %v", err)
+		}
+	}
+	if n := helperRuns(t, calls); n != 1 {
+		t.Fatalf("helper ran %d times, want once", n)
+	}
+}
+
+func TestRegistries_RetriesFailedCredentialLookup(t *testing.T) {
+	f, regs, calls := helperRegistry(t, `if [ ! -e "$0.ran" ]; then touch "$0.ran"; echo "keyring is locked" >&2; exit 1; fi
+echo '{"Username":"u","Secret":"p"}'
+`)
+	reg, _ := regs.For(mustHost(t, f.srv.URL))
+
+	_, _, _, err := reg.HeadDigest(context.Background(), "team/private", "1.0.0", "")
+	if !isAuthError(err) {
+		t.Fatalf("anonymous request should be refused, got %v", err)
+	}
+	digest, _, _, err := reg.HeadDigest(context.Background(), "team/private", "1.0.0", "")
+	if err != nil || digest != "sha256:ccc" {
+		t.Fatalf("after the auth failure: digest=%q err=%v", digest, err)
+	}
+	if n := helperRuns(t, calls); n != 2 {
+		t.Fatalf("helper ran %d times, want 2", n)
+	}
+}
+
+func TestRegistries_UsesKeychainForPrivateRepos(t *testing.T) {
+	f := newFakeRegistry(t, "bearer")
+	f.user, f.pass = "bot", "s3cret"
+	f.digests["team/private:1.0.0"] = "sha256:ccc"
+
+	host := mustHost(t, f.srv.URL)
+	p := writeAuthFile(t, t.TempDir(), "auth.json", `{"auths":{"`+host+`":{"auth":"`+b64("bot:s3cret")+`"}}}`)
+
+	reg, err := NewRegistriesWithKeychain(NewKeychain(p)).For(host)
+	if err != nil {
+		t.Fatalf("For error: %v", err)
+	}
+	digest, _, _, err := reg.HeadDigest(context.Background(), "team/private", "1.0.0", "")
+	if err != nil {
+		t.Fatalf("HeadDigest error: %v", err)
+	}
+	if digest != "sha256:ccc" {
+		t.Fatalf("digest = %q", digest)
+	}
+}
+
+func TestDistribution_IdentityTokenExchange(t *testing.T) {
+	f := newFakeRegistry(t, "bearer")
+	f.refreshToken = "refresh-me"
+	f.digests["team/app:1.0.0"] = "sha256:ddd"
+
+	d := NewDistribution(f.srv.URL)
+	d.SetCredentials(Credentials{IdentityToken: "refresh-me"})
+	if _, _, _, err := d.HeadDigest(context.Background(), "team/app", "1.0.0", ""); err != nil {
+		t.Fatalf("HeadDigest error: %v", err)
+	}
+	if f.lastScope != "repository:team/app:pull" {
+		t.Fatalf("scope = %q", f.lastScope)
+	}
+}
+
+func mustHost(t *testing.T, raw string) string {
+	t.Helper()
+	u, err := url.Parse(raw)
+	if err != nil {
+		t.Fatalf("parse %q: %v", raw, err)
+	}
+	return u.Host
+}
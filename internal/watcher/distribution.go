@@ -0,0 +1,604 @@
+package watcher
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// manifestAccept lists every manifest flavour we understand, indexes first,
+// so registries answer with the digest a plain `pull` would resolve to.
+var manifestAccept = strings.Join([]string{
+	"application/vnd.docker.distribution.manifest.list.v2+json",
+	"application/vnd.docker.distribution.manifest.v2+json",
+	"application/vnd.oci.image.index.v1+json",
+	"application/vnd.oci.image.manifest.v1+json",
+}, ", ")
+
+// requestTimeout bounds a single registry round-trip, body included, so one
+// hanging registry cannot stall a poll worker indefinitely.
+const requestTimeout = 30 * time.Second
+
+// credentialRetry spaces out lookups after a credential source failed, so a
+// broken helper is not run for every request to a public repository.
+const credentialRetry = time.Minute
+
+// CredentialSource looks credentials up when a request first needs them;
+// ok is false when the host should be used anonymously.
+type CredentialSource func() (c Credentials, ok bool, err error)
+
+// Distribution is a registry client for the OCI distribution spec. It does not
+// know any vendor: it probes /v2/, reads the WWW-Authenticate challenge and
+// fetches a token from whatever realm the registry advertises, falling back to
+// Basic auth when that is what the registry asks for.
+type Distribution struct {
+	base    string // scheme://host, no trailing slash
+	client  *http.Client
+	limiter *tokenBucket
+
+	mu        sync.Mutex
+	probed    bool
+	challenge *challenge             // nil when /v2/ answered without auth
+	tokens    map[string]bearerToken // by scope
+	now       func() time.Time
+	creds     Credentials
+
+	// source, when set, supplies creds lazily. credMu makes concurrent
+	// requests wait for one lookup instead of each running it.
+	credMu      sync.Mutex
+	source      CredentialSource
+	credsKnown  bool
+	credsFailed time.Time
+}
+
+// NewDistribution builds a client for a registry host such as "quay.io" or
+// "registry.lan:5000". A full "http://" or "https://" URL is used verbatim.
+func NewDistribution(host string) *Distribution {
+	rate, burst := rateLimitFor(host)
+	return &Distribution{
+		base:    registryBaseURL(host),
+		client:  &http.Client{Timeout: requestTimeout},
+		limiter: newTokenBucket(rate, burst),
+		tokens:  make(map[string]bearerToken),
+		now:     time.Now,
+	}
+}
+
+// SetCredentials configures what is sent for Basic challenges and when
+// requesting tokens from the realm. Cached tokens are discarded.
+func (d *Distribution) SetCredentials(c Credentials) {
+	d.mu.Lock()
+	d.creds = c
+	d.source = nil
+	d.tokens = make(map[string]bearerToken)
+	d.mu.Unlock()
+}
+
+// SetCredentialSource makes the client look credentials up on the first
+// authenticated request rather than up front. A failed lookup leaves the
+// client anonymous and is retried after credentialRetry; an auth failure
+// from the registry makes the next request look them up again.
+func (d *Distribution) SetCredentialSource(src CredentialSource) {
+	d.mu.Lock()
+	d.source, d.credsKnown, d.credsFailed = src, false, time.Time{}
+	d.creds = Credentials{}
+	d.tokens = make(map[string]bearerToken)
+	d.mu.Unlock()
+}
+
+// credentials returns what to authenticate with, consulting the source
+// when nothing usable is known yet.
+func (d *Distribution) credentials() Credentials {
+	d.mu.Lock()
+	c, ready := d.creds, d.source == nil || d.credsKnown
+	d.mu.Unlock()
+	if ready {
+		return c
+	}
+
+	d.credMu.Lock()
+	defer d.credMu.Unlock()
+	d.mu.Lock()
+	src, known, failed := d.source, d.credsKnown, d.credsFailed
+	c = d.creds
+	d.mu.Unlock()
+	if src == nil || known || (!failed.IsZero() && d.now().Sub(failed) < credentialRetry) {
+		return c
+	}
+
+	found, ok, err := src()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if err != nil {
+		// stay anonymous for now rather than failing public repositories
+		log.Printf("[registry] %s: credentials: %v", d.base, err)
+		d.credsFailed = d.now()
+		return d.creds
+	}
+	if !ok {
+		found = Credentials{}
+	}
+	if found != d.creds {
+		if ok {
+			log.Printf("[registry] %s: using stored credentials", d.base)
+		}
+		d.tokens = make(map[string]bearerToken)
+	}
+	d.creds, d.credsKnown, d.credsFailed = found, true, time.Time{}
+	return found
+}
+
+// forgetCredentials makes the next request consult the source again, after
+// the registry refused what was sent. Fixed credentials are kept.
+func (d *Distribution) forgetCredentials() {
+	d.mu.Lock()
+	if d.source != nil {
+		d.credsKnown, d.credsFailed = false, time.Time{}
+	}
+	d.mu.Unlock()
+}
+
+// SetBasicAuth is SetCredentials for a plain username and password.
+func (d *Distribution) SetBasicAuth(username, password string) {
+	d.SetCredentials(Credentials{Username: username, Password: password})
+}
+
+func registryBaseURL(host string) string {
+	host = strings.TrimRight(strings.TrimSpace(host), "/")
+	if strings.HasPrefix(host, "http://") || strings.HasPrefix(host, "https://") {
+		return host
+	}
+	if isInsecureRegistry(host) {
+		return "http://" + host
+	}
+	return "https://" + host
+}
+
+// isInsecureRegistry reports hosts that speak plain HTTP: loopback, plus any
+// listed in MD_INSECURE_REGISTRIES (comma separated, e.g. "registry.lan:5000").
+func isInsecureRegistry(host string) bool {
+	name := host
+	if i := strings.LastIndexByte(name, ':'); i >= 0 {
+		name = name[:i]
+	}
+	if name == "localhost" || name == "127.0.0.1" {
+		return true
+	}
+	for _, h := range strings.Split(os.Getenv("MD_INSECURE_REGISTRIES"), ",") {
+		if strings.EqualFold(strings.TrimSpace(h), host) {
+			return true
+		}
+	}
+	return false
+}
+
+// HeadDigest fetches the manifest headers of ref; notMod reports a 304 for
+// etag.
+func (d *Distribution) HeadDigest(ctx context.Context, repo, ref, etag string) (string, string, bool, error) {
+	return d.getManifestDigest(ctx, strings.ToLower(repo), ref, etag)
+}
+
+func (d *Distribution) getManifestDigest(ctx context.Context, repo, ref, etag string) (string, string, bool, error) {
+	url := fmt.Sprintf("%s/v2/%s/manifests/%s", d.base, repo, ref)
+
+	resp, err := d.do(ctx, repo, func() (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodHead, url, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Accept", manifestAccept)
+		if etag != "" {
+			req.Header.Set("If-None-Match", etag)
+		}
+		return req, nil
+	})
+	if err != nil {
+		return "", "", false, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusNotModified: // 304
+		return "", etag, true, nil
+
+	case http.StatusOK: // 200
+		digest := resp.Header.Get("Docker-Content-Digest")
+		etagOut := resp.Header.Get("Etag")
+		if digest == "" && etagOut == "" {
+			return "", "", false, fmt.Errorf("no digest/etag in response headers")
+		}
+		return digest, etagOut, false, nil
+
+	default: // 401, 404, 429, 5xx, ...
+		return "", "", false, statusError(resp)
+	}
+}
+
+// Tag listing is paginated by registries (n= / last= with a Link header); we
+// ask for big pages and stop after maxTagPages so a runaway registry cannot
+// keep us looping.
+const (
+	tagPageSize = 1000
+	maxTagPages = 50
+)
+
+func (d *Distribution) ListTags(ctx context.Context, repo string) ([]string, error) {
+	repo = strings.ToLower(repo)
+	next := fmt.Sprintf("%s/v2/%s/tags/list?n=%d", d.base, repo, tagPageSize)
+
+	var all []string
+	pages := 0
+	for next != "" {
+		if pages == maxTagPages {
+			log.Printf("[registry] %s: stopping tag listing after %d pages (%d tags)", repo, pages, len(all))
+			break
+		}
+		tags, link, err := d.listTagsPage(ctx, repo, next)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, tags...)
+		pages++
+		next = link
+	}
+
+	log.Printf("[registry] %s: listed %d tags in %d page(s)", repo, len(all), pages)
+	return all, nil
+}
+
+// listTagsPage fetches one page and returns the absolute URL of the next one,
+// or "" when the registry sent no rel="next" link.
+func (d *Distribution) listTagsPage(ctx context.Context, repo, pageURL string) ([]string, string, error) {
+	resp, err := d.do(ctx, repo, func() (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, pageURL, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Accept", "application/json")
+		return req, nil
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", statusError(resp)
+	}
+
+	var result struct {
+		Name string   `json:"name"`
+		Tags []string `json:"tags"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, "", fmt.Errorf("decode: %w", err)
+	}
+
+	next, err := nextLink(resp.Request.URL, resp.Header.Values("Link"))
+	if err != nil {
+		return nil, "", err
+	}
+	return result.Tags, next, nil
+}
+
+// nextLink extracts the rel="next" target from RFC 5988 Link headers, e.g.
+// </v2/app/tags/list?last=1.2.3&n=1000>; rel="next", resolved against base.
+func nextLink(base *url.URL, headers []string) (string, error) {
+	for _, h := range headers {
+		for _, part := range strings.Split(h, ",") {
+			target, params, ok := strings.Cut(part, ";")
+			if !ok {
+				continue
+			}
+			target = strings.TrimSpace(target)
+			if !strings.HasPrefix(target, "<") || !strings.HasSuffix(target, ">") {
+				continue
+			}
+			isNext := false
+			for _, p := range strings.Split(params, ";") {
+				k, v, _ := strings.Cut(strings.TrimSpace(p), "=")
+				if strings.EqualFold(k, "rel") && strings.EqualFold(strings.Trim(v, `"`), "next") {
+					isNext = true
+				}
+			}
+			if !isNext {
+				continue
+			}
+			u, err := base.Parse(strings.Trim(target, "<>"))
+			if err != nil {
+				return "", fmt.Errorf("bad Link %q: %w", target, err)
+			}
+			return u.String(), nil
+		}
+	}
+	return "", nil
+}
+
+// do sends the request built by newReq with whatever auth the registry wants
+// for a pull on repo. A 401 carrying a fresh challenge (e.g. an expired token
+// or a narrower scope) is answered once by re-authenticating and retrying.
+// Network errors, 429 and 5xx are retried up to maxRetries times with
+// backoff, honouring Retry-After when it is short enough to wait inline.
+func (d *Distribution) do(ctx context.Context, repo string, newReq func() (*http.Request, error)) (*http.Response, error) {
+	ch, err := d.probe(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("probe: %w", err)
+	}
+
+	scope := fmt.Sprintf("repository:%s:pull", repo)
+	reauthed := false
+	for retries := 0; ; {
+		req, err := newReq()
+		if err != nil {
+			return nil, fmt.Errorf("new request: %w", err)
+		}
+		if err := d.authorize(ctx, req, ch, scope); err != nil {
+			if isAuthError(err) {
+				d.forgetCredentials()
+			}
+			return nil, fmt.Errorf("token: %w", err)
+		}
+
+		resp, err := d.send(req)
+		if err != nil {
+			if ctx.Err() != nil || retries == maxRetries {
+				return nil, &RegistryError{Kind: ErrTransient, URL: req.URL.Redacted(), Err: err}
+			}
+			if err := sleepCtx(ctx, retryDelay(retries)); err != nil {
+				return nil, err
+			}
+			retries++
+			continue
+		}
+
+		switch {
+		case resp.StatusCode == http.StatusUnauthorized && !reauthed:
+			// Token revoked or expired early: fetch a new one and retry once.
+			next, ok := parseChallenge(resp.Header.Get("WWW-Authenticate"))
+			if !ok && ch == nil {
+				return resp, nil
+			}
+			resp.Body.Close()
+			d.dropToken(scope)
+			if ok {
+				if s := next.params["scope"]; s != "" {
+					scope = s
+				}
+				ch = &next
+			}
+			reauthed = true
+			continue
+
+		case retryable(resp.StatusCode) && retries < maxRetries:
+			wait := parseRetryAfter(resp.Header.Get("Retry-After"), time.Now())
+			if wait == 0 {
+				wait = retryDelay(retries)
+			}
+			if wait > maxInlineWait {
+				// let the caller's backoff carry the long wait
+				return resp, nil
+			}
+			resp.Body.Close()
+			if err := sleepCtx(ctx, wait); err != nil {
+				return nil, err
+			}
+			retries++
+			continue
+
+		case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+			d.forgetCredentials()
+		}
+		return resp, nil
+	}
+}
+
+// probe issues the unauthenticated GET /v2/ once per client and caches the
+// challenge it returns.
+func (d *Distribution) probe(ctx context.Context) (*challenge, error) {
+	d.mu.Lock()
+	if d.probed {
+		ch := d.challenge
+		d.mu.Unlock()
+		return ch, nil
+	}
+	d.mu.Unlock()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, d.base+"/v2/", nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := d.send(req)
+	if err != nil {
+		return nil, &RegistryError{Kind: ErrTransient, URL: req.URL.Redacted(), Err: err}
+	}
+	defer resp.Body.Close()
+
+	var ch *challenge
+	switch resp.StatusCode {
+	case http.StatusOK:
+		// anonymous access, no auth needed
+	case http.StatusUnauthorized:
+		c, ok := parseChallenge(resp.Header.Get("WWW-Authenticate"))
+		if !ok {
+			return nil, fmt.Errorf("401 without a usable WWW-Authenticate challenge")
+		}
+		ch = &c
+	default:
+		return nil, statusError(resp)
+	}
+
+	d.mu.Lock()
+	d.probed, d.challenge = true, ch
+	d.mu.Unlock()
+	return ch, nil
+}
+
+func (d *Distribution) authorize(ctx context.Context, req *http.Request, ch *challenge, scope string) error {
+	if ch == nil {
+		return nil
+	}
+	switch ch.scheme {
+	case "basic":
+		if creds := d.credentials(); creds.Username != "" {
+			req.SetBasicAuth(creds.Username, creds.Password)
+		}
+		return nil
+	case "bearer":
+		tok, err := d.tokenFor(ctx, ch, scope)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Authorization", "Bearer "+tok)
+		return nil
+	default:
+		return fmt.Errorf("unsupported auth scheme %q", ch.scheme)
+	}
+}
+
+func (d *Distribution) tokenFor(ctx context.Context, ch *challenge, scope string) (string, error) {
+	d.mu.Lock()
+	if tok, ok := d.tokens[scope]; ok && tok.fresh(d.now()) {
+		d.mu.Unlock()
+		return tok.value, nil
+	}
+	d.mu.Unlock()
+	creds := d.credentials()
+
+	realm := ch.params["realm"]
+	if realm == "" {
+		return "", fmt.Errorf("bearer challenge without realm")
+	}
+	u, err := url.Parse(realm)
+	if err != nil {
+		return "", fmt.Errorf("bad realm %q: %w", realm, err)
+	}
+
+	var req *http.Request
+	if creds.IdentityToken != "" {
+		// OAuth2 refresh-token grant, as docker does for identity tokens.
+		form := url.Values{
+			"grant_type":    {"refresh_token"},
+			"refresh_token": {creds.IdentityToken},
+			"service":       {ch.params["service"]},
+			"scope":         {scope},
+			"client_id":     {"magos-dominus"},
+		}
+		req, err = http.NewRequestWithContext(ctx, http.MethodPost, u.String(), strings.NewReader(form.Encode()))
+		if err != nil {
+			return "", err
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	} else {
+		q := u.Query()
+		if svc := ch.params["service"]; svc != "" {
+			q.Set("service", svc)
+		}
+		q.Set("scope", scope)
+		u.RawQuery = q.Encode()
+
+		req, err = http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+		if err != nil {
+			return "", err
+		}
+		if creds.Username != "" {
+			req.SetBasicAuth(creds.Username, creds.Password)
+		}
+	}
+
+	resp, err := d.send(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", statusError(resp)
+	}
+	var payload tokenResponse
+	if err := json.NewDecoder(resp.Body).Decode(&payload); err != nil {
+		return "", err
+	}
+	tok := payload.bearer(d.now())
+	if tok.value == "" {
+		return "", fmt.Errorf("empty token from %s", u.Host)
+	}
+
+	d.mu.Lock()
+	d.tokens[scope] = tok
+	d.mu.Unlock()
+	return tok.value, nil
+}
+
+// send is the single exit point to the network: it waits for the host's rate
+// limiter before issuing the request.
+func (d *Distribution) send(req *http.Request) (*http.Response, error) {
+	if err := d.limiter.Wait(req.Context()); err != nil {
+		return nil, err
+	}
+	return d.client.Do(req)
+}
+
+func (d *Distribution) dropToken(scope string) {
+	d.mu.Lock()
+	delete(d.tokens, scope)
+	d.mu.Unlock()
+}
+
+// challenge is a parsed WWW-Authenticate header, e.g.
+// Bearer realm="https://auth.docker.io/token",service="registry.docker.io".
+type challenge struct {
+	scheme string // lowercased: "bearer" or "basic"
+	params map[string]string
+}
+
+func parseChallenge(h string) (challenge, bool) {
+	h = strings.TrimSpace(h)
+	scheme, rest, _ := strings.Cut(h, " ")
+	if scheme == "" {
+		return challenge{}, false
+	}
+	c := challenge{scheme: strings.ToLower(scheme), params: make(map[string]string)}
+
+	for rest = strings.TrimSpace(rest); rest != ""; {
+		key, after, ok := strings.Cut(rest, "=")
+		if !ok {
+			break
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		after = strings.TrimLeft(after, " ")
+
+		var val string
+		if strings.HasPrefix(after, `"`) {
+			// quoted-string; values such as scope may contain commas
+			var b strings.Builder
+			i := 1
+			for ; i < len(after); i++ {
+				if after[i] == '\\' && i+1 < len(after) {
+					i++
+					b.WriteByte(after[i])
+					continue
+				}
+				if after[i] == '"' {
+					break
+				}
+				b.WriteByte(after[i])
+			}
+			val = b.String()
+			after = after[min(i+1, len(after)):]
+		} else {
+			val, after, _ = strings.Cut(after, ",")
+			after = "," + after
+		}
+		c.params[key] = strings.TrimSpace(val)
+
+		rest = strings.TrimLeft(strings.TrimSpace(after), ",")
+		rest = strings.TrimSpace(rest)
+	}
+	return c, true
+}
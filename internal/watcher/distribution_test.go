@@ -0,0 +1,472 @@
+package watcher
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	pc "github.com/jpvargasdev/magos-dominus/internal/policy"
+)
+
+// fakeRegistry is a tiny distribution-spec stand-in. auth selects the
+// challenge it sends: "bearer" (token served from /token), "basic" or "".
+type fakeRegistry struct {
+	t    *testing.T
+	srv  *httptest.Server
+	auth string
+	user string
+	pass string
+	// refreshToken, when set, is accepted via POST /token
+	refreshToken string
+
+	mu          sync.Mutex
+	digests     map[string]string   // "repo:ref" -> digest
+	manifests   map[string][]byte   // "repo:ref" -> body served on GET
+	blobs       map[string][]byte   // "repo@digest" -> blob body
+	tags        map[string][]string // repo -> tags
+	referrers   map[string][]any    // "repo@digest" -> descriptors; nil map answers 404
+	pageSize    int                 // caps n= on tag listing, 0 means unlimited
+	failNext    []int               // statuses returned (in order) before serving normally
+	retryAfter  string              // Retry-After sent with failNext statuses
+	requests    int                 // authorized /v2/<repo>/... requests seen
+	tokenTTL    int                 // expires_in sent with tokens, 0 omits it
+	tokenCalls  int
+	lastScope   string
+	issuedToken string
+}
+
+func newFakeRegistry(t *testing.T, auth string) *fakeRegistry {
+	t.Helper()
+	f := &fakeRegistry{
+		t:           t,
+		auth:        auth,
+		digests:     make(map[string]string),
+		manifests:   make(map[string][]byte),
+		blobs:       make(map[string][]byte),
+		tags:        make(map[string][]string),
+		issuedToken: "tok-1",
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/token", f.serveToken)
+	mux.HandleFunc("/v2/", f.serveV2)
+	f.srv = httptest.NewServer(mux)
+	t.Cleanup(f.srv.Close)
+	return f
+}
+
+func (f *fakeRegistry) serveToken(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	f.tokenCalls++
+	f.lastScope = r.URL.Query().Get("scope")
+	tok := f.issuedToken
+	f.mu.Unlock()
+
+	if r.Method == http.MethodPost {
+		// OAuth2 refresh-token grant
+		if r.FormValue("grant_type") != "refresh_token" || r.FormValue("refresh_token") != f.refreshToken {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		f.mu.Lock()
+		f.lastScope = r.FormValue("scope")
+		f.mu.Unlock()
+		_ = json.NewEncoder(w).Encode(map[string]any{"access_token": tok})
+		return
+	}
+	if f.user != "" {
+		if u, p, ok := r.BasicAuth(); !ok || u != f.user || p != f.pass {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+	}
+	body := map[string]any{"token": tok}
+	if f.tokenTTL > 0 {
+		body["expires_in"] = f.tokenTTL
+	}
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+func (f *fakeRegistry) authorized(r *http.Request) bool {
+	switch f.auth {
+	case "bearer":
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		return r.Header.Get("Authorization") == "Bearer "+f.issuedToken
+	case "basic":
+		u, p, ok := r.BasicAuth()
+		return ok && u == f.user && p == f.pass
+	}
+	return true
+}
+
+func (f *fakeRegistry) challenge(w http.ResponseWriter) {
+	switch f.auth {
+	case "bearer":
+		w.Header().Set("WWW-Authenticate",
+			`Bearer realm="`+f.srv.URL+`/token",service="fake-registry"`)
+	case "basic":
+		w.Header().Set("WWW-Authenticate", `Basic realm="fake"`)
+	}
+	w.WriteHeader(http.StatusUnauthorized)
+}
+
+func (f *fakeRegistry) serveV2(w http.ResponseWriter, r *http.Request) {
+	if !f.authorized(r) {
+		f.challenge(w)
+		return
+	}
+	path := strings.TrimPrefix(r.URL.Path, "/v2/")
+	if path == "" {
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.requests++
+	if len(f.failNext) > 0 {
+		status := f.failNext[0]
+		f.failNext = f.failNext[1:]
+		if f.retryAfter != "" {
+			w.Header().Set("Retry-After", f.retryAfter)
+		}
+		w.WriteHeader(status)
+		return
+	}
+
+	if repo, ok := strings.CutSuffix(path, "/tags/list"); ok {
+		tags, found := f.tags[repo]
+		if !found {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		page, next := paginate(tags, r.URL.Query(), f.pageSize)
+		if next != "" {
+			w.Header().Set("Link", fmt.Sprintf(`</v2/%s/tags/list?n=%d&last=%s>; rel="next"`, repo, len(page), next))
+		}
+		_ = json.NewEncoder(w).Encode(map[string]any{"name": repo, "tags": page})
+		return
+	}
+
+	if i := strings.LastIndex(path, "/referrers/"); i >= 0 && f.referrers != nil {
+		refs := f.referrers[path[:i]+"@"+path[i+len("/referrers/"):]]
+		_ = json.NewEncoder(w).Encode(map[string]any{"mediaType": mediaTypeOCIIndex, "manifests": refs})
+		return
+	}
+
+	if i := strings.LastIndex(path, "/blobs/"); i >= 0 {
+		body, found := f.blobs[path[:i]+"@"+path[i+len("/blobs/"):]]
+		if !found {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write(body)
+		return
+	}
+
+	if i := strings.LastIndex(path, "/manifests/"); i >= 0 {
+		repo, ref := path[:i], path[i+len("/manifests/"):]
+		digest, found := f.digests[repo+":"+ref]
+		if !found {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		etag := `"` + digest + `"`
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("Docker-Content-Digest", digest)
+		w.Header().Set("Etag", etag)
+		if body, ok := f.manifests[repo+":"+ref]; ok {
+			var head struct {
+				MediaType string `json:"mediaType"`
+			}
+			_ = json.Unmarshal(body, &head)
+			w.Header().Set("Content-Type", head.MediaType)
+			if r.Method == http.MethodGet {
+				_, _ = w.Write(body)
+			}
+		}
+		return
+	}
+	w.WriteHeader(http.StatusNotFound)
+}
+
+// putManifest stores m under repo:ref and repo:<digest> and returns the digest.
+func (f *fakeRegistry) putManifest(repo, ref string, m any) string {
+	f.t.Helper()
+	body, err := json.Marshal(m)
+	if err != nil {
+		f.t.Fatalf("marshal manifest: %v", err)
+	}
+	digest := sha256Digest(body)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, r := range []string{ref, digest} {
+		if r == "" {
+			continue
+		}
+		f.digests[repo+":"+r] = digest
+		f.manifests[repo+":"+r] = body
+	}
+	return digest
+}
+
+// putBlob stores v (JSON-encoded) as a blob of repo and returns its descriptor.
+func (f *fakeRegistry) putBlob(repo string, v any) map[string]any {
+	f.t.Helper()
+	body, err := json.Marshal(v)
+	if err != nil {
+		f.t.Fatalf("marshal blob: %v", err)
+	}
+	digest := sha256Digest(body)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.blobs[repo+"@"+digest] = body
+	return map[string]any{"mediaType": "application/vnd.oci.image.config.v1+json", "digest": digest, "size": len(body)}
+}
+
+// paginate serves tags after ?last= in pages of ?n= (capped by limit) and
+// returns the last tag of the page when more remain.
+func paginate(tags []string, q url.Values, limit int) ([]string, string) {
+	start := 0
+	if last := q.Get("last"); last != "" {
+		start = slices.Index(tags, last) + 1
+	}
+	n, _ := strconv.Atoi(q.Get("n"))
+	if limit > 0 && (n == 0 || n > limit) {
+		n = limit
+	}
+	if n == 0 || start+n >= len(tags) {
+		return tags[start:], ""
+	}
+	page := tags[start : start+n]
+	return page, page[len(page)-1]
+}
+
+// resolveDigest runs the policy stage and then the registry stage, as
+// Watcher.check does.
+func resolveDigest(r Registry, repo, ref, policy string, opts pc.Options) (digest, candidate string, err error) {
+	candidate, _, err = resolveCandidate(context.Background(), r, repo, ref, policy, opts)
+	if err != nil {
+		return "", "", err
+	}
+	digest, _, _, err = r.HeadDigest(context.Background(), repo, candidate, "")
+	return digest, candidate, err
+}
+
+func TestDistribution_BearerChallengeFlow(t *testing.T) {
+	f := newFakeRegistry(t, "bearer")
+	f.digests["team/app:1.0.0"] = "sha256:aaa"
+
+	d := NewDistribution(f.srv.URL)
+	digest, etag, notMod, err := d.HeadDigest(context.Background(), "Team/App", "1.0.0", "")
+	if err != nil {
+		t.Fatalf("HeadDigest error: %v", err)
+	}
+	if digest != "sha256:aaa" || notMod {
+		t.Fatalf("got digest=%q notMod=%v", digest, notMod)
+	}
+	if f.lastScope != "repository:team/app:pull" {
+		t.Fatalf("unexpected scope %q", f.lastScope)
+	}
+
+	// Second call reuses the cached token and honours the ETag.
+	_, _, notMod, err = d.HeadDigest(context.Background(), "team/app", "1.0.0", etag)
+	if err != nil {
+		t.Fatalf("HeadDigest (etag) error: %v", err)
+	}
+	if !notMod {
+		t.Fatalf("expected notMod=true with matching etag")
+	}
+	if f.tokenCalls != 1 {
+		t.Fatalf("expected 1 token request, got %d", f.tokenCalls)
+	}
+}
+
+func TestDistribution_RefreshesTokenOn401(t *testing.T) {
+	f := newFakeRegistry(t, "bearer")
+	f.digests["team/app:1.0.0"] = "sha256:aaa"
+	d := NewDistribution(f.srv.URL)
+
+	if _, _, _, err := d.HeadDigest(context.Background(), "team/app", "1.0.0", ""); err != nil {
+		t.Fatalf("HeadDigest error: %v", err)
+	}
+
+	// Registry rotates tokens; the cached one is now rejected.
+	f.mu.Lock()
+	f.issuedToken = "tok-2"
+	f.mu.Unlock()
+
+	if _, _, _, err := d.HeadDigest(context.Background(), "team/app", "1.0.0", ""); err != nil {
+		t.Fatalf("HeadDigest after rotation error: %v", err)
+	}
+	if f.tokenCalls != 2 {
+		t.Fatalf("expected token refresh, got %d token calls", f.tokenCalls)
+	}
+}
+
+func TestDistribution_BasicAuthFallback(t *testing.T) {
+	f := newFakeRegistry(t, "basic")
+	f.user, f.pass = "bot", "s3cret"
+	f.tags["app"] = []string{"1.0.0", "1.1.0"}
+	f.digests["app:1.1.0"] = "sha256:bbb"
+
+	d := NewDistribution(f.srv.URL)
+	if _, _, _, err := d.HeadDigest(context.Background(), "app", "1.1.0", ""); err == nil {
+		t.Fatalf("expected error without credentials")
+	}
+
+	d.SetBasicAuth("bot", "s3cret")
+	digest, ref, err := resolveDigest(d, "app", "latest", "semver", pc.Options{})
+	if err != nil {
+		t.Fatalf("HeadDigest error: %v", err)
+	}
+	if ref != "1.1.0" || digest != "sha256:bbb" {
+		t.Fatalf("got ref=%q digest=%q", ref, digest)
+	}
+}
+
+func TestDistribution_AnonymousRegistryAndNotFound(t *testing.T) {
+	f := newFakeRegistry(t, "")
+	d := NewDistribution(f.srv.URL)
+
+	_, _, _, err := d.HeadDigest(context.Background(), "missing", "1.0.0", "")
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected os.ErrNotExist, got %v", err)
+	}
+	if f.tokenCalls != 0 {
+		t.Fatalf("anonymous registry should not hit the token endpoint")
+	}
+}
+
+func TestDistribution_ListTagsFollowsPagination(t *testing.T) {
+	f := newFakeRegistry(t, "bearer")
+	f.pageSize = 2
+	for i := 0; i < 7; i++ {
+		f.tags["team/app"] = append(f.tags["team/app"], fmt.Sprintf("1.0.%d", i))
+	}
+	f.digests["team/app:1.0.6"] = "sha256:newest"
+
+	d := NewDistribution(f.srv.URL)
+	tags, err := d.ListTags(context.Background(), "team/app")
+	if err != nil {
+		t.Fatalf("ListTags error: %v", err)
+	}
+	if !slices.Equal(tags, f.tags["team/app"]) {
+		t.Fatalf("got %v, want %v", tags, f.tags["team/app"])
+	}
+
+	_, ref, err := resolveDigest(d, "team/app", "1.0.0", "semver", pc.Options{})
+	if err != nil {
+		t.Fatalf("HeadDigest error: %v", err)
+	}
+	if ref != "1.0.6" {
+		t.Fatalf("semver should see the last page, got %q", ref)
+	}
+}
+
+func TestDistribution_SemverRange(t *testing.T) {
+	f := newFakeRegistry(t, "")
+	f.tags["library/postgres"] = []string{"15.8.0", "16.3.0", "16.4.0", "17.0.0"}
+	f.digests["library/postgres:16.4.0"] = "sha256:pg16"
+
+	d := NewDistribution(f.srv.URL)
+	digest, ref, err := resolveDigest(d, "library/postgres", "16.3.0", "semver",
+		pc.Options{Range: ">=16.0.0 <17.0.0"})
+	if err != nil {
+		t.Fatalf("HeadDigest error: %v", err)
+	}
+	if ref != "16.4.0" || digest != "sha256:pg16" {
+		t.Fatalf("range should keep postgres on 16.x, got %s (%s)", ref, digest)
+	}
+}
+
+func TestDistribution_RegexPolicy(t *testing.T) {
+	f := newFakeRegistry(t, "")
+	f.tags["minio/minio"] = []string{"RELEASE.2024-04-18T19-09-19Z", "RELEASE.2024-05-01T12-00-00Z", "latest"}
+	f.digests["minio/minio:RELEASE.2024-05-01T12-00-00Z"] = "sha256:may"
+
+	d := NewDistribution(f.srv.URL)
+	opts := pc.Options{Filter: `^RELEASE\.(?P<ts>.+)$`, Order: pc.OrderAlphabetical}
+	digest, ref, err := resolveDigest(d, "minio/minio", "RELEASE.2024-04-18T19-09-19Z", "regex", opts)
+	if err != nil {
+		t.Fatalf("HeadDigest error: %v", err)
+	}
+	if ref != "RELEASE.2024-05-01T12-00-00Z" || digest != "sha256:may" {
+		t.Fatalf("got %s (%s)", ref, digest)
+	}
+}
+
+func TestDistribution_NumericalPolicy(t *testing.T) {
+	f := newFakeRegistry(t, "")
+	f.tags["team/api"] = []string{"build-999", "build-1043", "build-1042", "nightly"}
+	f.digests["team/api:build-1043"] = "sha256:b1043"
+
+	d := NewDistribution(f.srv.URL)
+	digest, ref, err := resolveDigest(d, "team/api", "build-1042", "numerical", pc.Options{})
+	if err != nil {
+		t.Fatalf("HeadDigest error: %v", err)
+	}
+	if ref != "build-1043" || digest != "sha256:b1043" {
+		t.Fatalf("got %s (%s)", ref, digest)
+	}
+}
+
+func TestNextLink(t *testing.T) {
+	base, _ := url.Parse("https://quay.io/v2/org/app/tags/list?n=1000")
+	got, err := nextLink(base, []string{`</v2/org/app/tags/list?last=v1.2.3&n=1000>; rel="next"`})
+	if err != nil {
+		t.Fatalf("nextLink error: %v", err)
+	}
+	if want := "https://quay.io/v2/org/app/tags/list?last=v1.2.3&n=1000"; got != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+
+	got, _ = nextLink(base, []string{`<https://example.com/other>; rel="prev"`})
+	if got != "" {
+		t.Fatalf("expected no next link, got %q", got)
+	}
+}
+
+func TestParseChallenge(t *testing.T) {
+	c, ok := parseChallenge(`Bearer realm="https://auth.docker.io/token",service="registry.docker.io",scope="repository:library/postgres:pull,push"`)
+	if !ok {
+		t.Fatalf("expected challenge to parse")
+	}
+	if c.scheme != "bearer" {
+		t.Fatalf("scheme = %q", c.scheme)
+	}
+	want := map[string]string{
+		"realm":   "https://auth.docker.io/token",
+		"service": "registry.docker.io",
+		"scope":   "repository:library/postgres:pull,push",
+	}
+	for k, v := range want {
+		if c.params[k] != v {
+			t.Fatalf("param %s = %q, want %q", k, c.params[k], v)
+		}
+	}
+
+	c, ok = parseChallenge(`Basic realm=Registry`)
+	if !ok || c.scheme != "basic" || c.params["realm"] != "Registry" {
+		t.Fatalf("unexpected basic challenge: %+v", c)
+	}
+
+	if _, ok := parseChallenge(""); ok {
+		t.Fatalf("empty header should not parse")
+	}
+}
@@ -0,0 +1,50 @@
+package watcher
+
+import (
+	"context"
+	"strings"
+)
+
+const (
+	dockerHubHost     = "docker.io"
+	dockerHubRegistry = "registry-1.docker.io"
+)
+
+// DockerHub talks to registry-1.docker.io; its challenge points at
+// auth.docker.io. Official images live under the implicit "library/" namespace.
+type DockerHub struct {
+	*Distribution
+}
+
+func NewDockerHub() *DockerHub {
+	return &DockerHub{Distribution: NewDistribution(dockerHubRegistry)}
+}
+
+// dockerHubRepo turns "postgres" into "library/postgres" and lowercases.
+func dockerHubRepo(repo string) string {
+	repo = strings.ToLower(strings.Trim(repo, "/"))
+	if !strings.Contains(repo, "/") {
+		repo = "library/" + repo
+	}
+	return repo
+}
+
+func (d *DockerHub) HeadDigest(ctx context.Context, repo, ref, etag string) (string, string, bool, error) {
+	return d.Distribution.HeadDigest(ctx, dockerHubRepo(repo), ref, etag)
+}
+
+func (d *DockerHub) ListTags(ctx context.Context, repo string) ([]string, error) {
+	return d.Distribution.ListTags(ctx, dockerHubRepo(repo))
+}
+
+func (d *DockerHub) Manifest(ctx context.Context, repo, ref string) (*Manifest, error) {
+	return d.Distribution.Manifest(ctx, dockerHubRepo(repo), ref)
+}
+
+func (d *DockerHub) Blob(ctx context.Context, repo, digest string) ([]byte, error) {
+	return d.Distribution.Blob(ctx, dockerHubRepo(repo), digest)
+}
+
+func (d *DockerHub) Referrers(ctx context.Context, repo, digest, artifactType string) ([]Descriptor, error) {
+	return d.Distribution.Referrers(ctx, dockerHubRepo(repo), digest, artifactType)
+}
@@ -0,0 +1,170 @@
+package watcher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrorKind classifies registry failures by what the caller should do next.
+type ErrorKind int
+
+const (
+	ErrTransient   ErrorKind = iota // network error or 5xx: retry soon
+	ErrRateLimited                  // 429: retry after Retry-After
+	ErrAuth                         // 401/403: credentials or permissions
+	ErrNotFound                     // 404: repository or tag is gone
+)
+
+func (k ErrorKind) String() string {
+	switch k {
+	case ErrRateLimited:
+		return "rate-limited"
+	case ErrAuth:
+		return "auth"
+	case ErrNotFound:
+		return "not-found"
+	default:
+		return "transient"
+	}
+}
+
+// RegistryError is returned for every failed registry round-trip.
+type RegistryError struct {
+	Kind       ErrorKind
+	Status     int           // HTTP status, 0 for network errors
+	RetryAfter time.Duration // from the Retry-After header, if any
+	URL        string
+	Err        error // underlying network error, if any
+}
+
+func (e *RegistryError) Error() string {
+	var b strings.Builder
+	b.WriteString(e.Kind.String())
+	if e.Status != 0 {
+		fmt.Fprintf(&b, ": status %d", e.Status)
+	}
+	if e.URL != "" {
+		fmt.Fprintf(&b, " from %s", e.URL)
+	}
+	if e.Err != nil {
+		fmt.Fprintf(&b, ": %v", e.Err)
+	}
+	if e.RetryAfter > 0 {
+		fmt.Fprintf(&b, " (retry after %s)", e.RetryAfter)
+	}
+	return b.String()
+}
+
+// Unwrap keeps errors.Is(err, os.ErrNotExist) working for 404s.
+func (e *RegistryError) Unwrap() error {
+	if e.Kind == ErrNotFound {
+		return os.ErrNotExist
+	}
+	return e.Err
+}
+
+// statusError classifies a non-successful response.
+func statusError(resp *http.Response) *RegistryError {
+	e := &RegistryError{Status: resp.StatusCode}
+	if resp.Request != nil {
+		e.URL = resp.Request.URL.Redacted()
+	}
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests:
+		e.Kind = ErrRateLimited
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		e.Kind = ErrAuth
+	case resp.StatusCode == http.StatusNotFound:
+		e.Kind = ErrNotFound
+	default:
+		e.Kind = ErrTransient
+	}
+	e.RetryAfter = parseRetryAfter(resp.Header.Get("Retry-After"), time.Now())
+	return e
+}
+
+// retryable reports statuses worth retrying within the same call.
+func retryable(status int) bool {
+	return status == http.StatusTooManyRequests || status >= 500
+}
+
+// isAuthError reports a registry or token realm refusing our credentials.
+func isAuthError(err error) bool {
+	var re *RegistryError
+	return errors.As(err, &re) && re.Kind == ErrAuth
+}
+
+// parseRetryAfter accepts delta-seconds or an HTTP date.
+func parseRetryAfter(h string, now time.Time) time.Duration {
+	h = strings.TrimSpace(h)
+	if h == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(h); err == nil && secs > 0 {
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(h); err == nil && t.After(now) {
+		return t.Sub(now)
+	}
+	return 0
+}
+
+// In-call retries: a few quick attempts with exponential backoff and jitter.
+// Anything that asks us to wait longer than maxInlineWait is handed back to
+// the scheduler instead of holding a worker.
+const (
+	maxRetries    = 3
+	retryBase     = 500 * time.Millisecond
+	maxInlineWait = 10 * time.Second
+)
+
+// retryDelay is retryBase·2^attempt with "equal jitter" (half fixed, half random).
+func retryDelay(attempt int) time.Duration {
+	d := retryBase << attempt
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+func sleepCtx(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+// Per-target backoff caps once a target keeps failing across polls.
+const (
+	maxTransientBackoff = 30 * time.Minute
+	maxPermanentBackoff = 6 * time.Hour
+)
+
+// targetBackoff is how long a target that has failed `failures` times in a
+// row should wait before its next check: its interval doubled per failure,
+// capped by error kind, and never sooner than the registry's Retry-After.
+func targetBackoff(err error, interval time.Duration, failures int) time.Duration {
+	limit := maxTransientBackoff
+	var retryAfter time.Duration
+	var re *RegistryError
+	if errors.As(err, &re) {
+		if re.Kind == ErrNotFound || re.Kind == ErrAuth {
+			limit = maxPermanentBackoff
+		}
+		retryAfter = re.RetryAfter
+	}
+
+	d := interval
+	for i := 1; i < failures && d < limit; i++ {
+		d *= 2
+	}
+	return max(min(d, limit), retryAfter)
+}
@@ -0,0 +1,124 @@
+package watcher
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestStatusError_Classification(t *testing.T) {
+	tests := []struct {
+		status int
+		want   ErrorKind
+	}{
+		{http.StatusTooManyRequests, ErrRateLimited},
+		{http.StatusUnauthorized, ErrAuth},
+		{http.StatusForbidden, ErrAuth},
+		{http.StatusNotFound, ErrNotFound},
+		{http.StatusBadGateway, ErrTransient},
+	}
+	for _, tc := range tests {
+		e := statusError(&http.Response{StatusCode: tc.status, Header: http.Header{}})
+		if e.Kind != tc.want {
+			t.Fatalf("status %d: kind %v, want %v", tc.status, e.Kind, tc.want)
+		}
+	}
+
+	nf := statusError(&http.Response{StatusCode: http.StatusNotFound, Header: http.Header{}})
+	if !errors.Is(nf, os.ErrNotExist) {
+		t.Fatalf("404 should unwrap to os.ErrNotExist")
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	if got := parseRetryAfter("120", now); got != 2*time.Minute {
+		t.Fatalf("seconds form: got %v", got)
+	}
+	date := now.Add(90 * time.Second).Format(http.TimeFormat)
+	if got := parseRetryAfter(date, now); got != 90*time.Second {
+		t.Fatalf("date form: got %v", got)
+	}
+	if got := parseRetryAfter("soon", now); got != 0 {
+		t.Fatalf("garbage: got %v", got)
+	}
+}
+
+func TestTargetBackoff(t *testing.T) {
+	notFound := &RegistryError{Kind: ErrNotFound}
+	transient := &RegistryError{Kind: ErrTransient}
+
+	if got := targetBackoff(notFound, time.Minute, 1); got != time.Minute {
+		t.Fatalf("first failure should wait one interval, got %v", got)
+	}
+	if got := targetBackoff(notFound, time.Minute, 4); got != 8*time.Minute {
+		t.Fatalf("fourth failure: got %v", got)
+	}
+	if got := targetBackoff(notFound, time.Minute, 50); got != maxPermanentBackoff {
+		t.Fatalf("404 cap: got %v", got)
+	}
+	if got := targetBackoff(transient, time.Minute, 50); got != maxTransientBackoff {
+		t.Fatalf("transient cap: got %v", got)
+	}
+	limited := &RegistryError{Kind: ErrRateLimited, RetryAfter: time.Hour}
+	if got := targetBackoff(limited, time.Minute, 1); got != time.Hour {
+		t.Fatalf("Retry-After should win: got %v", got)
+	}
+}
+
+func TestDistribution_RetriesTransientErrors(t *testing.T) {
+	f := newFakeRegistry(t, "")
+	f.digests["team/app:1.0.0"] = "sha256:aaa"
+	f.failNext = []int{http.StatusServiceUnavailable, http.StatusTooManyRequests}
+	f.retryAfter = "0"
+
+	d := NewDistribution(f.srv.URL)
+	digest, _, _, err := d.HeadDigest(context.Background(), "team/app", "1.0.0", "")
+	if err != nil {
+		t.Fatalf("HeadDigest error: %v", err)
+	}
+	if digest != "sha256:aaa" || f.requests != 3 {
+		t.Fatalf("digest=%q after %d requests", digest, f.requests)
+	}
+}
+
+func TestDistribution_LongRetryAfterIsReturned(t *testing.T) {
+	f := newFakeRegistry(t, "")
+	f.digests["team/app:1.0.0"] = "sha256:aaa"
+	f.failNext = []int{http.StatusTooManyRequests}
+	f.retryAfter = "3600"
+
+	d := NewDistribution(f.srv.URL)
+	_, _, _, err := d.HeadDigest(context.Background(), "team/app", "1.0.0", "")
+	var re *RegistryError
+	if !errors.As(err, &re) {
+		t.Fatalf("expected *RegistryError, got %v", err)
+	}
+	if re.Kind != ErrRateLimited || re.RetryAfter != time.Hour {
+		t.Fatalf("got kind=%v retryAfter=%v", re.Kind, re.RetryAfter)
+	}
+	if f.requests != 1 {
+		t.Fatalf("should not wait an hour inline, made %d requests", f.requests)
+	}
+}
+
+func TestSchedule_BacksOffFailingTarget(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	s := newSchedule([]Target{{Interval: 60}}, 0, now)
+
+	var last time.Duration
+	for i := 0; i < 5; i++ {
+		last = s.fail(0, now, &RegistryError{Kind: ErrNotFound})
+	}
+	if s.failures[0] != 5 || last < 14*time.Minute {
+		t.Fatalf("after 5 failures: failures=%d delay=%v", s.failures[0], last)
+	}
+
+	s.done(0, now)
+	if s.failures[0] != 0 || s.next[0].Sub(now) > 2*time.Minute {
+		t.Fatalf("success should reset backoff, next in %v", s.next[0].Sub(now))
+	}
+}
@@ -0,0 +1,168 @@
+package watcher
+
+import (
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// githubActionsIssuer is the OIDC issuer of GitHub Actions workflow
+// identities, the default ProvenancePolicy.Issuer.
+const githubActionsIssuer = "https://token.actions.githubusercontent.com"
+
+// Fulcio certificate extensions naming the OIDC issuer: the raw string of
+// the original extension and the DER UTF8String of its replacement.
+var (
+	oidFulcioIssuerV1 = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 57264, 1, 1}
+	oidFulcioIssuerV2 = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 57264, 1, 8}
+)
+
+// FulcioRoots are the certificate authorities keyless signatures must chain
+// to, such as Sigstore's public Fulcio instance or GitHub's own.
+type FulcioRoots struct {
+	roots         *x509.CertPool
+	intermediates *x509.CertPool
+}
+
+// ParseFulcioRoots reads PEM certificates; self-signed ones are trusted as
+// roots and the rest are used as intermediates.
+func ParseFulcioRoots(data []byte) (*FulcioRoots, error) {
+	f := &FulcioRoots{roots: x509.NewCertPool(), intermediates: x509.NewCertPool()}
+	n := 0
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("fulcio roots: %w", err)
+		}
+		if selfSigned(cert) {
+			f.roots.AddCert(cert)
+			n++
+		} else {
+			f.intermediates.AddCert(cert)
+		}
+	}
+	if n == 0 {
+		return nil, errors.New("fulcio roots: no self-signed certificate")
+	}
+	return f, nil
+}
+
+// LoadFulcioRoots reads the PEM file named by MD_FULCIO_ROOTS. It returns
+// nil when the variable is unset, which leaves keyless signatures untrusted.
+func LoadFulcioRoots() (*FulcioRoots, error) {
+	path := strings.TrimSpace(os.Getenv("MD_FULCIO_ROOTS"))
+	if path == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("MD_FULCIO_ROOTS: %w", err)
+	}
+	return ParseFulcioRoots(data)
+}
+
+// verify checks that chain (leaf first) leads to a root and was valid at
+// signedAt, and that the leaf names the identity and issuer p requires.
+func (f *FulcioRoots) verify(chain []*x509.Certificate, signedAt time.Time, p ProvenancePolicy) error {
+	if f == nil {
+		return errors.New("no Fulcio roots configured (MD_FULCIO_ROOTS)")
+	}
+	leaf := chain[0]
+	inter := f.intermediates.Clone()
+	for _, c := range chain[1:] {
+		// roots come from the configuration, never from the signature
+		if !selfSigned(c) {
+			inter.AddCert(c)
+		}
+	}
+	if _, err := leaf.Verify(x509.VerifyOptions{
+		Roots:         f.roots,
+		Intermediates: inter,
+		CurrentTime:   signedAt,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+	}); err != nil {
+		return fmt.Errorf("certificate: %w", err)
+	}
+
+	if id := certIdentity(leaf); !matchBuilder(p.Identity, id) {
+		return fmt.Errorf("certificate identity %q is not %q", id, p.Identity)
+	}
+	want := p.Issuer
+	if want == "" {
+		want = githubActionsIssuer
+	}
+	if iss := certIssuer(leaf); iss != want {
+		return fmt.Errorf("certificate issuer %q is not %q", iss, want)
+	}
+	return nil
+}
+
+func selfSigned(c *x509.Certificate) bool {
+	return c.IsCA && c.CheckSignatureFrom(c) == nil
+}
+
+// certIdentity is the subject a Fulcio certificate was issued to: a URI
+// such as a GitHub workflow ref, or an email address.
+func certIdentity(c *x509.Certificate) string {
+	switch {
+	case len(c.URIs) > 0:
+		return c.URIs[0].String()
+	case len(c.EmailAddresses) > 0:
+		return c.EmailAddresses[0]
+	}
+	return ""
+}
+
+func certIssuer(c *x509.Certificate) string {
+	for _, ext := range c.Extensions {
+		switch {
+		case ext.Id.Equal(oidFulcioIssuerV2):
+			var s string
+			if _, err := asn1.Unmarshal(ext.Value, &s); err == nil {
+				return s
+			}
+		case ext.Id.Equal(oidFulcioIssuerV1):
+			return string(ext.Value)
+		}
+	}
+	return ""
+}
+
+// parseCertificates reads DER (rawBytes) or PEM certificates, leaf first.
+func parseCertificates(der [][]byte, pemData string) ([]*x509.Certificate, error) {
+	var out []*x509.Certificate
+	for _, b := range der {
+		c, err := x509.ParseCertificate(b)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, c)
+	}
+	rest := []byte(pemData)
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		c, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, c)
+	}
+	return out, nil
+}
@@ -0,0 +1,176 @@
+package watcher
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	neturl "net/url"
+	"strings"
+)
+
+const (
+	mediaTypeDockerList     = "application/vnd.docker.distribution.manifest.list.v2+json"
+	mediaTypeOCIIndex       = "application/vnd.oci.image.index.v1+json"
+	mediaTypeDockerManifest = "application/vnd.docker.distribution.manifest.v2+json"
+	mediaTypeOCIManifest    = "application/vnd.oci.image.manifest.v1+json"
+
+	// maxManifestSize guards against registries streaming junk at us; the
+	// distribution spec recommends registries accept at least 4 MiB.
+	maxManifestSize = 4 << 20
+)
+
+// Descriptor points at a manifest or blob (OCI image-spec descriptor).
+type Descriptor struct {
+	MediaType    string            `json:"mediaType"`
+	Digest       string            `json:"digest"`
+	Size         int64             `json:"size"`
+	Platform     *Platform         `json:"platform,omitempty"`
+	ArtifactType string            `json:"artifactType,omitempty"`
+	Annotations  map[string]string `json:"annotations,omitempty"`
+}
+
+// Manifest is an image manifest or an image index / manifest list; which
+// fields are set depends on MediaType.
+type Manifest struct {
+	MediaType    string            `json:"mediaType"`
+	ArtifactType string            `json:"artifactType,omitempty"`
+	Config       Descriptor        `json:"config"`
+	Layers       []Descriptor      `json:"layers"`
+	Manifests    []Descriptor      `json:"manifests"`
+	Subject      *Descriptor       `json:"subject,omitempty"`
+	Annotations  map[string]string `json:"annotations,omitempty"`
+
+	Digest string `json:"-"` // digest of the raw bytes as served
+	Raw    []byte `json:"-"`
+}
+
+// IsIndex reports a multi-platform index rather than a single image.
+func (m *Manifest) IsIndex() bool {
+	switch m.MediaType {
+	case mediaTypeDockerList, mediaTypeOCIIndex:
+		return true
+	}
+	return m.MediaType == "" && len(m.Manifests) > 0
+}
+
+// Manifest fetches a manifest or index by tag or digest.
+func (d *Distribution) Manifest(ctx context.Context, repo, ref string) (*Manifest, error) {
+	repo = strings.ToLower(repo)
+	url := fmt.Sprintf("%s/v2/%s/manifests/%s", d.base, repo, ref)
+
+	resp, err := d.do(ctx, repo, func() (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Accept", manifestAccept)
+		return req, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, statusError(resp)
+	}
+	raw, err := io.ReadAll(io.LimitReader(resp.Body, maxManifestSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("read manifest: %w", err)
+	}
+	if len(raw) > maxManifestSize {
+		return nil, fmt.Errorf("manifest %s@%s exceeds %d bytes", repo, ref, maxManifestSize)
+	}
+
+	var m Manifest
+	if err := json.Unmarshal(raw, &m); err != nil {
+		return nil, fmt.Errorf("decode manifest: %w", err)
+	}
+	if m.MediaType == "" {
+		m.MediaType, _, _ = strings.Cut(resp.Header.Get("Content-Type"), ";")
+	}
+	m.Raw = raw
+	m.Digest = sha256Digest(raw)
+	if h := resp.Header.Get("Docker-Content-Digest"); strings.HasPrefix(h, "sha256:") && h != m.Digest {
+		return nil, fmt.Errorf("manifest digest mismatch: registry says %s, content is %s", h, m.Digest)
+	}
+	return &m, nil
+}
+
+// Referrers lists the manifests whose subject is digest (OCI 1.1 referrers
+// API), keeping those of artifactType when it is set. Registries without the
+// API answer 404, reported as os.ErrNotExist.
+func (d *Distribution) Referrers(ctx context.Context, repo, digest, artifactType string) ([]Descriptor, error) {
+	repo = strings.ToLower(repo)
+	url := fmt.Sprintf("%s/v2/%s/referrers/%s", d.base, repo, digest)
+	if artifactType != "" {
+		url += "?artifactType=" + neturl.QueryEscape(artifactType)
+	}
+
+	resp, err := d.do(ctx, repo, func() (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Accept", mediaTypeOCIIndex)
+		return req, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, statusError(resp)
+	}
+	var idx Manifest
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxManifestSize)).Decode(&idx); err != nil {
+		return nil, fmt.Errorf("decode referrers: %w", err)
+	}
+	// the filter is optional for registries, so apply it again
+	var out []Descriptor
+	for _, desc := range idx.Manifests {
+		if artifactType == "" || desc.ArtifactType == artifactType {
+			out = append(out, desc)
+		}
+	}
+	return out, nil
+}
+
+// Blob fetches a (small) blob such as an image config and verifies its digest.
+func (d *Distribution) Blob(ctx context.Context, repo, digest string) ([]byte, error) {
+	repo = strings.ToLower(repo)
+	url := fmt.Sprintf("%s/v2/%s/blobs/%s", d.base, repo, digest)
+
+	resp, err := d.do(ctx, repo, func() (*http.Request, error) {
+		return http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, statusError(resp)
+	}
+	raw, err := io.ReadAll(io.LimitReader(resp.Body, maxManifestSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("read blob: %w", err)
+	}
+	if len(raw) > maxManifestSize {
+		return nil, fmt.Errorf("blob %s exceeds %d bytes", digest, maxManifestSize)
+	}
+	if strings.HasPrefix(digest, "sha256:") && sha256Digest(raw) != digest {
+		return nil, fmt.Errorf("blob digest mismatch for %s", digest)
+	}
+	return raw, nil
+}
+
+func sha256Digest(b []byte) string {
+	sum := sha256.Sum256(b)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
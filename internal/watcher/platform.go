@@ -0,0 +1,88 @@
+package watcher
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"strings"
+)
+
+// Platform identifies one image in a multi-arch index, e.g. linux/arm64/v8.
+type Platform struct {
+	OS           string `json:"os"`
+	Architecture string `json:"architecture"`
+	Variant      string `json:"variant,omitempty"`
+}
+
+func (p Platform) String() string {
+	s := p.OS + "/" + p.Architecture
+	if p.Variant != "" {
+		s += "/" + p.Variant
+	}
+	return s
+}
+
+// ParsePlatform accepts "os/arch[/variant]" or "host" for the platform this
+// binary runs on.
+func ParsePlatform(s string) (Platform, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if s == "host" {
+		return hostPlatform(), nil
+	}
+	parts := strings.Split(s, "/")
+	if len(parts) < 2 || len(parts) > 3 || parts[0] == "" || parts[1] == "" {
+		return Platform{}, fmt.Errorf("platform %q: want os/arch[/variant] or host", s)
+	}
+	p := Platform{OS: parts[0], Architecture: parts[1]}
+	if len(parts) == 3 {
+		p.Variant = parts[2]
+	}
+	return p, nil
+}
+
+func hostPlatform() Platform {
+	return Platform{OS: runtime.GOOS, Architecture: runtime.GOARCH}
+}
+
+// matches compares a wanted platform against an index entry. An empty wanted
+// variant matches any variant, and arm64 treats a missing variant as v8.
+func (p Platform) matches(entry *Platform) bool {
+	if entry == nil || p.OS != entry.OS || p.Architecture != entry.Architecture {
+		return false
+	}
+	if p.Variant == "" {
+		return true
+	}
+	v := entry.Variant
+	if v == "" && entry.Architecture == "arm64" {
+		v = "v8"
+	}
+	return p.Variant == v
+}
+
+// platformDigest resolves ref to the digest of the image for p. For a
+// single-platform manifest the manifest digest itself is returned.
+func platformDigest(ctx context.Context, r Registry, repo, ref string, p Platform) (string, error) {
+	m, err := r.Manifest(ctx, repo, ref)
+	if err != nil {
+		return "", fmt.Errorf("manifest: %w", err)
+	}
+	if !m.IsIndex() {
+		return m.Digest, nil
+	}
+	desc, err := m.forPlatform(p)
+	if err != nil {
+		return "", err
+	}
+	return desc.Digest, nil
+}
+
+// forPlatform picks the entry of an index that matches p.
+func (m *Manifest) forPlatform(p Platform) (Descriptor, error) {
+	for _, desc := range m.Manifests {
+		if p.matches(desc.Platform) {
+			return desc, nil
+		}
+	}
+	return Descriptor{}, fmt.Errorf("no %s image in index %s", p, m.Digest)
+}
@@ -0,0 +1,109 @@
+package watcher
+
+import (
+	"context"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/jpvargasdev/magos-dominus/internal/events"
+	"github.com/jpvargasdev/magos-dominus/internal/state"
+)
+
+func TestParsePlatform(t *testing.T) {
+	p, err := ParsePlatform("linux/arm/v7")
+	if err != nil || p.OS != "linux" || p.Architecture != "arm" || p.Variant != "v7" {
+		t.Fatalf("got %+v, %v", p, err)
+	}
+	p, err = ParsePlatform("host")
+	if err != nil || p.OS != runtime.GOOS || p.Architecture != runtime.GOARCH {
+		t.Fatalf("host: got %+v, %v", p, err)
+	}
+	if _, err := ParsePlatform("arm64"); err == nil {
+		t.Fatalf("expected error for missing os")
+	}
+}
+
+func TestPlatform_Matches(t *testing.T) {
+	arm64 := Platform{OS: "linux", Architecture: "arm64"}
+	if !arm64.matches(&Platform{OS: "linux", Architecture: "arm64", Variant: "v8"}) {
+		t.Fatalf("empty variant should match any")
+	}
+	v8 := Platform{OS: "linux", Architecture: "arm64", Variant: "v8"}
+	if !v8.matches(&Platform{OS: "linux", Architecture: "arm64"}) {
+		t.Fatalf("arm64 entry without variant is v8")
+	}
+	if arm64.matches(&Platform{OS: "unknown", Architecture: "unknown"}) {
+		t.Fatalf("attestation entries must not match")
+	}
+}
+
+// index builds a two-platform OCI index with the given image digests.
+func index(amd64, arm64 string) map[string]any {
+	return map[string]any{
+		"schemaVersion": 2,
+		"mediaType":     mediaTypeOCIIndex,
+		"manifests": []map[string]any{
+			{"mediaType": mediaTypeOCIManifest, "digest": amd64, "size": 1,
+				"platform": map[string]string{"os": "linux", "architecture": "amd64"}},
+			{"mediaType": mediaTypeOCIManifest, "digest": arm64, "size": 1,
+				"platform": map[string]string{"os": "linux", "architecture": "arm64"}},
+		},
+	}
+}
+
+func TestCheck_PlatformDigestIgnoresOtherArchRebuilds(t *testing.T) {
+	f := newFakeRegistry(t, "")
+	host := mustHost(t, f.srv.URL)
+	f.putManifest("team/app", "1.0.0", index("sha256:amd-1", "sha256:arm-1"))
+
+	tg := Target{
+		Name:     "/git/app/compose.yml",
+		Image:    ImageRef{Registry: host, Owner: "team", Name: "app", Tag: "1.0.0"},
+		Policy:   "digest",
+		Platform: "linux/arm64",
+	}
+	st := state.New(filepath.Join(t.TempDir(), "state.json"))
+	em := make(events.ChanEmitter, 8)
+	w := New([]Target{tg}, em)
+	regs := NewRegistriesWithKeychain(nil)
+	ctx := context.Background()
+
+	if err := w.check(ctx, regs, st, tg); err != nil {
+		t.Fatalf("seed check: %v", err)
+	}
+	if e, _ := st.Get(stateKey(tg)); e.Digest != "sha256:arm-1" {
+		t.Fatalf("state should hold the arm64 digest, got %q", e.Digest)
+	}
+
+	// amd64-only rebuild: index digest changes, arm64 image does not
+	f.putManifest("team/app", "1.0.0", index("sha256:amd-2", "sha256:arm-1"))
+	if err := w.check(ctx, regs, st, tg); err != nil {
+		t.Fatalf("check: %v", err)
+	}
+	if len(em) != 0 {
+		t.Fatalf("amd64 rebuild should not emit for an arm64 target")
+	}
+
+	// arm64 rebuild: emit, pinning the index digest by default
+	idx := f.putManifest("team/app", "1.0.0", index("sha256:amd-2", "sha256:arm-2"))
+	if err := w.check(ctx, regs, st, tg); err != nil {
+		t.Fatalf("check: %v", err)
+	}
+	if len(em) != 1 {
+		t.Fatalf("expected 1 event, got %d", len(em))
+	}
+	if ev := <-em; ev.Digest != idx {
+		t.Fatalf("event should pin index %s, got %s", idx, ev.Digest)
+	}
+
+	// PinPlatform writes the per-platform digest instead
+	tg.PinPlatform = true
+	f.putManifest("team/app", "1.0.0", index("sha256:amd-2", "sha256:arm-3"))
+	if err := w.check(ctx, regs, st, tg); err != nil {
+		t.Fatalf("check: %v", err)
+	}
+	if ev := <-em; ev.Digest != "sha256:arm-3" {
+		t.Fatalf("event should pin arm64 image, got %s", ev.Digest)
+	}
+}
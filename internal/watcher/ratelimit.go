@@ -0,0 +1,92 @@
+package watcher
+
+import (
+	"context"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Requests per second and burst allowed against one registry host. Docker Hub
+// gets a tighter default because of its anonymous pull limits.
+const (
+	defaultRegistryRate  = 5.0
+	defaultRegistryBurst = 10
+	dockerHubRate        = 1.0
+	dockerHubBurst       = 5
+)
+
+// tokenBucket is a small token-bucket limiter shared by every request a
+// Distribution client makes.
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64 // tokens per second
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64, burst int) *tokenBucket {
+	if burst < 1 {
+		burst = 1
+	}
+	return &tokenBucket{rate: rate, burst: float64(burst), tokens: float64(burst), last: time.Now()}
+}
+
+// Wait blocks until a token is available or ctx is done.
+func (b *tokenBucket) Wait(ctx context.Context) error {
+	for {
+		b.mu.Lock()
+		now := time.Now()
+		b.tokens = min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+		b.last = now
+		if b.tokens >= 1 {
+			b.tokens--
+			b.mu.Unlock()
+			return nil
+		}
+		wait := time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+		b.mu.Unlock()
+
+		t := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
+}
+
+// rateLimitFor returns the limiter settings for a registry host. Overrides
+// come from MD_REGISTRY_RATE_LIMITS, e.g. "docker.io=0.5:3,ghcr.io=10" where
+// each value is requests-per-second with an optional ":burst".
+func rateLimitFor(host string) (float64, int) {
+	rate, burst := defaultRegistryRate, defaultRegistryBurst
+	if canonicalHost(host) == dockerHubHost {
+		rate, burst = dockerHubRate, dockerHubBurst
+	}
+
+	for _, entry := range strings.Split(os.Getenv("MD_REGISTRY_RATE_LIMITS"), ",") {
+		h, spec, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		if !ok || canonicalHost(h) != canonicalHost(host) {
+			continue
+		}
+		rs, bs, hasBurst := strings.Cut(spec, ":")
+		r, err := strconv.ParseFloat(rs, 64)
+		if err != nil || r <= 0 {
+			log.Printf("[registry] ignoring bad rate limit %q", entry)
+			continue
+		}
+		rate = r
+		if hasBurst {
+			if b, err := strconv.Atoi(bs); err == nil && b > 0 {
+				burst = b
+			}
+		}
+	}
+	return rate, burst
+}
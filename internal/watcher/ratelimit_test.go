@@ -0,0 +1,48 @@
+package watcher
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestTokenBucket_ThrottlesAfterBurst(t *testing.T) {
+	b := newTokenBucket(20, 2) // 20 req/s after a burst of 2
+	ctx := context.Background()
+
+	start := time.Now()
+	for i := 0; i < 4; i++ {
+		if err := b.Wait(ctx); err != nil {
+			t.Fatalf("Wait error: %v", err)
+		}
+	}
+	// two tokens are free, the other two cost ~50ms each
+	if elapsed := time.Since(start); elapsed < 80*time.Millisecond {
+		t.Fatalf("expected throttling, 4 waits took %v", elapsed)
+	}
+}
+
+func TestTokenBucket_HonoursContext(t *testing.T) {
+	b := newTokenBucket(0.01, 1)
+	_ = b.Wait(context.Background())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := b.Wait(ctx); err == nil {
+		t.Fatalf("expected context error while bucket is empty")
+	}
+}
+
+func TestRateLimitFor(t *testing.T) {
+	t.Setenv("MD_REGISTRY_RATE_LIMITS", "docker.io=0.5:3, ghcr.io=10")
+
+	if r, b := rateLimitFor("registry-1.docker.io"); r != 0.5 || b != 3 {
+		t.Fatalf("docker hub = %v/%d", r, b)
+	}
+	if r, b := rateLimitFor("ghcr.io"); r != 10 || b != defaultRegistryBurst {
+		t.Fatalf("ghcr = %v/%d", r, b)
+	}
+	if r, b := rateLimitFor("quay.io"); r != defaultRegistryRate || b != defaultRegistryBurst {
+		t.Fatalf("quay = %v/%d", r, b)
+	}
+}
@@ -0,0 +1,96 @@
+package watcher
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	pc "github.com/jpvargasdev/magos-dominus/internal/policy"
+)
+
+// Registry is what the watcher needs from an image registry: resolve a ref
+// to a manifest digest, list tags, and fetch manifests and blobs when the
+// digest alone is not enough. Policies are applied by resolveCandidate.
+type Registry interface {
+	HeadDigest(ctx context.Context, repo, ref, etag string) (digest, etagOut string, notMod bool, err error)
+	ListTags(ctx context.Context, repo string) ([]string, error)
+	Manifest(ctx context.Context, repo, ref string) (*Manifest, error)
+	Blob(ctx context.Context, repo, digest string) ([]byte, error)
+}
+
+// Registries hands out one Registry client per host, created on first use,
+// so token caches are shared by every target living on the same registry.
+type Registries struct {
+	mu       sync.Mutex
+	clients  map[string]Registry
+	keychain *Keychain
+}
+
+// NewRegistries uses the docker/podman auth files of the current user.
+func NewRegistries() *Registries {
+	return NewRegistriesWithKeychain(DefaultKeychain())
+}
+
+func NewRegistriesWithKeychain(k *Keychain) *Registries {
+	return &Registries{clients: make(map[string]Registry), keychain: k}
+}
+
+// For returns the client for the registry host of an ImageRef. Credentials
+// are looked up by the client when a request needs them, so a helper that
+// fails once is asked again later instead of leaving the host anonymous.
+func (r *Registries) For(host string) (Registry, error) {
+	host = canonicalHost(host)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if c, ok := r.clients[host]; ok {
+		return c, nil
+	}
+
+	var c Registry
+	switch host {
+	case "ghcr.io":
+		c = NewGHCR()
+	case dockerHubHost:
+		c = NewDockerHub()
+	default:
+		c = NewDistribution(host)
+	}
+	if k := r.keychain; k != nil {
+		if s, ok := c.(interface{ SetCredentialSource(CredentialSource) }); ok {
+			s.SetCredentialSource(func() (Credentials, bool, error) { return k.Resolve(host) })
+		}
+	}
+	r.clients[host] = c
+	return c, nil
+}
+
+// canonicalHost folds the different spellings of Docker Hub into one key.
+func canonicalHost(host string) string {
+	host = strings.ToLower(strings.TrimSpace(host))
+	switch host {
+	case "", "docker.io", "index.docker.io", "registry-1.docker.io", "registry.hub.docker.com":
+		return dockerHubHost
+	}
+	return host
+}
+
+// resolveCandidate runs the policy stage shared by every backend: tag-picking
+// policies list tags and choose one allowed by opts (relative to ref, the tag
+// currently deployed), otherwise the ref is used as-is. The decision explains
+// a tag-picking evaluation, including a failed one; it is nil otherwise.
+func resolveCandidate(ctx context.Context, r Registry, repo, ref, policy string, opts pc.Options) (candidate string, decision *pc.Decision, err error) {
+	if !pc.IsTagPolicy(policy) {
+		return ref, nil, nil
+	}
+	tags, err := r.ListTags(ctx, repo)
+	if err != nil {
+		return "", nil, fmt.Errorf("list tags: %w", err)
+	}
+	d, err := pc.Resolve(policy, tags, ref, opts)
+	if err != nil {
+		return "", d, fmt.Errorf("resolve %s: %w", strings.ToLower(policy), err)
+	}
+	return d.Chosen, d, nil
+}
@@ -0,0 +1,59 @@
+package watcher
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestRegistries_ForSelectsByHost(t *testing.T) {
+	regs := NewRegistries()
+
+	tests := []struct {
+		host string
+		want string
+	}{
+		{"ghcr.io", "*watcher.GHCR"},
+		{"GHCR.io", "*watcher.GHCR"},
+		{"docker.io", "*watcher.DockerHub"},
+		{"index.docker.io", "*watcher.DockerHub"},
+		{"", "*watcher.DockerHub"},
+	}
+	for _, tc := range tests {
+		r, err := regs.For(tc.host)
+		if err != nil {
+			t.Fatalf("For(%q) error: %v", tc.host, err)
+		}
+		if got := fmt.Sprintf("%T", r); got != tc.want {
+			t.Fatalf("For(%q) = %s, want %s", tc.host, got, tc.want)
+		}
+	}
+
+	a, _ := regs.For("docker.io")
+	b, _ := regs.For("registry-1.docker.io")
+	if a != b {
+		t.Fatalf("expected Docker Hub aliases to share one client")
+	}
+}
+
+func TestRegistries_ForUnknownHostUsesDistribution(t *testing.T) {
+	r, err := NewRegistries().For("quay.io")
+	if err != nil {
+		t.Fatalf("For(quay.io) error: %v", err)
+	}
+	if _, ok := r.(*Distribution); !ok {
+		t.Fatalf("expected generic *Distribution for quay.io, got %T", r)
+	}
+}
+
+func TestDockerHubRepo_LibraryNamespace(t *testing.T) {
+	tests := map[string]string{
+		"postgres":           "library/postgres",
+		"library/postgres":   "library/postgres",
+		"LinuxServer/Sonarr": "linuxserver/sonarr",
+	}
+	for in, want := range tests {
+		if got := dockerHubRepo(in); got != want {
+			t.Fatalf("dockerHubRepo(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
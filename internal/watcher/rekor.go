@@ -0,0 +1,152 @@
+package watcher
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// tlogEntry is a Rekor transparency log entry as carried by a Sigstore
+// bundle or cosign's bundle annotation: the canonicalized entry and the
+// log's signed promise (SignedEntryTimestamp) that it was recorded at
+// integratedTime.
+type tlogEntry struct {
+	body           []byte
+	integratedTime int64
+	logIndex       int64
+	logID          string // hex SHA-256 of the log's public key
+	set            []byte
+}
+
+// RekorKeys are the public keys of the transparency logs whose entries date
+// keyless signatures, by log ID.
+type RekorKeys struct {
+	keys map[string]*CosignKey
+}
+
+// ParseRekorKeys reads PEM public keys, such as the public Rekor instance's
+// from its /api/v1/log/publicKey endpoint.
+func ParseRekorKeys(data []byte) (*RekorKeys, error) {
+	k := &RekorKeys{keys: make(map[string]*CosignKey)}
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		key, err := ParseCosignKey("rekor", pem.EncodeToMemory(block))
+		if err != nil {
+			return nil, err
+		}
+		id := sha256.Sum256(block.Bytes)
+		k.keys[hex.EncodeToString(id[:])] = key
+	}
+	if len(k.keys) == 0 {
+		return nil, errors.New("rekor keys: no PEM public key")
+	}
+	return k, nil
+}
+
+// LoadRekorKeys reads the PEM file named by MD_REKOR_KEYS. It returns nil
+// when the variable is unset; keyless signatures then need a certificate
+// that is still valid.
+func LoadRekorKeys() (*RekorKeys, error) {
+	path := strings.TrimSpace(os.Getenv("MD_REKOR_KEYS"))
+	if path == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("MD_REKOR_KEYS: %w", err)
+	}
+	return ParseRekorKeys(data)
+}
+
+// verify checks e's SignedEntryTimestamp and returns the time the log
+// recorded the entry.
+func (k *RekorKeys) verify(e *tlogEntry) (time.Time, error) {
+	key := k.keys[e.logID]
+	if key == nil {
+		return time.Time{}, fmt.Errorf("transparency log %s is not trusted", e.logID)
+	}
+	if len(e.set) == 0 || !key.verify(e.promised(), e.set) {
+		return time.Time{}, errors.New("transparency log entry: signed entry timestamp does not verify")
+	}
+	return time.Unix(e.integratedTime, 0), nil
+}
+
+// promised is what the SignedEntryTimestamp signs: the canonical JSON of
+// the entry's fields, keys sorted.
+func (e *tlogEntry) promised() []byte {
+	b, _ := json.Marshal(struct {
+		Body           string `json:"body"`
+		IntegratedTime int64  `json:"integratedTime"`
+		LogID          string `json:"logID"`
+		LogIndex       int64  `json:"logIndex"`
+	}{base64.StdEncoding.EncodeToString(e.body), e.integratedTime, e.logID, e.logIndex})
+	return b
+}
+
+// covers checks that e records payload signed by cert, so an entry made for
+// another statement cannot date this one. It reads "dsse" and "intoto"
+// entries.
+func (e *tlogEntry) covers(payload []byte, cert *x509.Certificate) error {
+	type hashJSON struct {
+		Algorithm string `json:"algorithm"`
+		Value     string `json:"value"`
+	}
+	var body struct {
+		Kind string `json:"kind"`
+		Spec struct {
+			// dsse
+			PayloadHash *hashJSON `json:"payloadHash"`
+			Signatures  []struct {
+				Verifier []byte `json:"verifier"`
+			} `json:"signatures"`
+			// intoto
+			Content struct {
+				PayloadHash *hashJSON `json:"payloadHash"`
+				Envelope    struct {
+					Signatures []struct {
+						PublicKey []byte `json:"publicKey"`
+					} `json:"signatures"`
+				} `json:"envelope"`
+			} `json:"content"`
+		} `json:"spec"`
+	}
+	if err := json.Unmarshal(e.body, &body); err != nil {
+		return fmt.Errorf("transparency log entry: %w", err)
+	}
+	hash := body.Spec.PayloadHash
+	verifiers := make([][]byte, 0, len(body.Spec.Signatures))
+	for _, s := range body.Spec.Signatures {
+		verifiers = append(verifiers, s.Verifier)
+	}
+	if body.Kind == "intoto" {
+		hash = body.Spec.Content.PayloadHash
+		verifiers = verifiers[:0]
+		for _, s := range body.Spec.Content.Envelope.Signatures {
+			verifiers = append(verifiers, s.PublicKey)
+		}
+	}
+
+	sum := sha256.Sum256(payload)
+	if hash == nil || hash.Algorithm != "sha256" || hash.Value != hex.EncodeToString(sum[:]) {
+		return errors.New("transparency log entry is for another statement")
+	}
+	for _, v := range verifiers {
+		if block, _ := pem.Decode(v); block != nil && bytes.Equal(block.Bytes, cert.Raw) {
+			return nil
+		}
+	}
+	return errors.New("transparency log entry is for another certificate")
+}
@@ -0,0 +1,101 @@
+package watcher
+
+import (
+	"math/rand"
+	"time"
+)
+
+const (
+	defaultPollInterval = 1 * time.Minute
+	minPollInterval     = 10 * time.Second
+	// pollJitter spreads each reschedule by ±10% so targets that share an
+	// interval drift apart instead of hitting the registry in the same second.
+	pollJitter = 0.10
+)
+
+// schedule tracks when each target is next due. Indexes match the targets
+// slice it was built from.
+type schedule struct {
+	intervals []time.Duration
+	next      []time.Time
+	failures  []int  // consecutive failed checks, drives backoff
+	running   []bool // handed to a worker and not yet done or failed
+	rnd       *rand.Rand
+}
+
+// newSchedule makes every target due immediately (first pass seeds state),
+// then honours Target.Interval, falling back to def.
+func newSchedule(targets []Target, def time.Duration, now time.Time) *schedule {
+	if def <= 0 {
+		def = defaultPollInterval
+	}
+	s := &schedule{
+		intervals: make([]time.Duration, len(targets)),
+		next:      make([]time.Time, len(targets)),
+		failures:  make([]int, len(targets)),
+		running:   make([]bool, len(targets)),
+		rnd:       rand.New(rand.NewSource(now.UnixNano())),
+	}
+	for i, t := range targets {
+		iv := def
+		if t.Interval > 0 {
+			iv = time.Duration(t.Interval) * time.Second
+		}
+		s.intervals[i] = max(iv, minPollInterval)
+		s.next[i] = now
+	}
+	return s
+}
+
+// due returns the indexes of targets whose next check is at or before now
+// and that are not already running.
+func (s *schedule) due(now time.Time) []int {
+	var out []int
+	for i, n := range s.next {
+		if !s.running[i] && !n.After(now) {
+			out = append(out, i)
+		}
+	}
+	return out
+}
+
+// start marks target i as handed to a worker until done or fail.
+func (s *schedule) start(i int) {
+	s.running[i] = true
+}
+
+// done reschedules target i one jittered interval after now.
+func (s *schedule) done(i int, now time.Time) {
+	s.running[i] = false
+	s.failures[i] = 0
+	s.next[i] = now.Add(s.jitter(s.intervals[i]))
+}
+
+// fail reschedules target i after a failed check, backing off further with
+// every consecutive failure. It returns the chosen delay.
+func (s *schedule) fail(i int, now time.Time, err error) time.Duration {
+	s.running[i] = false
+	s.failures[i]++
+	d := s.jitter(targetBackoff(err, s.intervals[i], s.failures[i]))
+	s.next[i] = now.Add(d)
+	return d
+}
+
+// nextWake is the earliest next-due time across targets that are not
+// running; ok is false when every target is running.
+func (s *schedule) nextWake() (earliest time.Time, ok bool) {
+	for i, n := range s.next {
+		if s.running[i] {
+			continue
+		}
+		if !ok || n.Before(earliest) {
+			earliest, ok = n, true
+		}
+	}
+	return earliest, ok
+}
+
+func (s *schedule) jitter(d time.Duration) time.Duration {
+	spread := float64(d) * pollJitter
+	return d + time.Duration((s.rnd.Float64()*2-1)*spread)
+}
@@ -0,0 +1,48 @@
+package watcher
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSchedule_PerTargetIntervals(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	targets := []Target{
+		{Name: "app", Interval: 60},
+		{Name: "base", Interval: 3600},
+		{Name: "default"},
+	}
+	s := newSchedule(targets, 5*time.Minute, now)
+
+	if got := s.due(now); len(got) != 3 {
+		t.Fatalf("all targets should be due on start, got %v", got)
+	}
+	for i := range targets {
+		s.done(i, now)
+	}
+
+	want := []time.Duration{time.Minute, time.Hour, 5 * time.Minute}
+	for i, w := range want {
+		d := s.next[i].Sub(now)
+		lo := time.Duration(float64(w) * (1 - pollJitter))
+		hi := time.Duration(float64(w) * (1 + pollJitter))
+		if d < lo || d > hi {
+			t.Fatalf("target %d next in %v, want within [%v, %v]", i, d, lo, hi)
+		}
+	}
+
+	if got := s.due(now.Add(2 * time.Minute)); len(got) != 1 || got[0] != 0 {
+		t.Fatalf("after 2m only the 1m target should be due, got %v", got)
+	}
+	wake, ok := s.nextWake()
+	if !ok || !wake.Equal(s.next[0]) {
+		t.Fatalf("nextWake = %v, want %v", wake, s.next[0])
+	}
+}
+
+func TestSchedule_FloorsTinyIntervals(t *testing.T) {
+	s := newSchedule([]Target{{Interval: 1}}, 0, time.Now())
+	if s.intervals[0] != minPollInterval {
+		t.Fatalf("interval = %v, want floor %v", s.intervals[0], minPollInterval)
+	}
+}
@@ -0,0 +1,88 @@
+package watcher
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"time"
+)
+
+const (
+	// defaultTokenLifetime is what the distribution token spec says to assume
+	// when the response carries no expires_in.
+	defaultTokenLifetime = 60 * time.Second
+	// tokenRefreshMargin renews a token this long before it expires so a
+	// request never races the expiry.
+	tokenRefreshMargin = 10 * time.Second
+)
+
+// bearerToken is a cached registry token with its expected expiry.
+type bearerToken struct {
+	value    string
+	issuedAt time.Time
+	expires  time.Time
+}
+
+// fresh reports whether the token can still be sent at now.
+func (t bearerToken) fresh(now time.Time) bool {
+	return t.value != "" && now.Before(t.expires.Add(-tokenRefreshMargin))
+}
+
+// tokenResponse is the body of a token realm response (docker token spec and
+// OAuth2 flavours).
+type tokenResponse struct {
+	Token       string `json:"token"`
+	AccessToken string `json:"access_token"`
+	ExpiresIn   int    `json:"expires_in"`
+	IssuedAt    string `json:"issued_at"`
+}
+
+// bearer turns a token response received at now into a cache entry. The
+// lifetime comes from expires_in, then the JWT "exp" claim, then the spec
+// default. Expiry is measured from our own clock; a server issued_at is only
+// used when it makes the token expire sooner.
+func (r tokenResponse) bearer(now time.Time) bearerToken {
+	tok := bearerToken{value: r.Token, issuedAt: now}
+	if tok.value == "" {
+		tok.value = r.AccessToken
+	}
+
+	switch {
+	case r.ExpiresIn > 0:
+		lifetime := time.Duration(r.ExpiresIn) * time.Second
+		tok.expires = now.Add(lifetime)
+		if issued, err := time.Parse(time.RFC3339, r.IssuedAt); err == nil {
+			tok.issuedAt = issued
+			if e := issued.Add(lifetime); e.Before(tok.expires) {
+				tok.expires = e
+			}
+		}
+	default:
+		if exp, ok := jwtExpiry(tok.value); ok {
+			tok.expires = exp
+		} else {
+			tok.expires = now.Add(defaultTokenLifetime)
+		}
+	}
+	return tok
+}
+
+// jwtExpiry reads the "exp" claim of a JWT without verifying it; we only use
+// it to decide when to refresh.
+func jwtExpiry(token string) (time.Time, bool) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return time.Time{}, false
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return time.Time{}, false
+	}
+	var claims struct {
+		Exp int64 `json:"exp"`
+	}
+	if err := json.Unmarshal(raw, &claims); err != nil || claims.Exp == 0 {
+		return time.Time{}, false
+	}
+	return time.Unix(claims.Exp, 0), true
+}
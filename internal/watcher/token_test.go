@@ -0,0 +1,80 @@
+package watcher
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestTokenResponse_Lifetime(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	tok := tokenResponse{Token: "abc", ExpiresIn: 300}.bearer(now)
+	if !tok.expires.Equal(now.Add(5 * time.Minute)) {
+		t.Fatalf("expires_in: got %v", tok.expires)
+	}
+
+	// an issued_at in the past shortens the remaining lifetime
+	issued := now.Add(-2 * time.Minute).Format(time.RFC3339)
+	tok = tokenResponse{AccessToken: "abc", ExpiresIn: 300, IssuedAt: issued}.bearer(now)
+	if tok.value != "abc" || !tok.expires.Equal(now.Add(3*time.Minute)) {
+		t.Fatalf("issued_at: got value=%q expires=%v", tok.value, tok.expires)
+	}
+
+	claims := base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf(`{"exp":%d}`, now.Add(time.Hour).Unix())))
+	tok = tokenResponse{Token: "hdr." + claims + ".sig"}.bearer(now)
+	if !tok.expires.Equal(now.Add(time.Hour)) {
+		t.Fatalf("jwt exp: got %v", tok.expires)
+	}
+
+	tok = tokenResponse{Token: "opaque"}.bearer(now)
+	if !tok.expires.Equal(now.Add(defaultTokenLifetime)) {
+		t.Fatalf("default lifetime: got %v", tok.expires)
+	}
+}
+
+func TestBearerToken_RefreshesBeforeExpiry(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	tok := bearerToken{value: "abc", expires: now.Add(time.Minute)}
+	if !tok.fresh(now) {
+		t.Fatalf("token should be fresh right after issue")
+	}
+	if tok.fresh(now.Add(time.Minute - tokenRefreshMargin)) {
+		t.Fatalf("token should be refreshed inside the margin")
+	}
+}
+
+func TestDistribution_ProactiveTokenRefresh(t *testing.T) {
+	f := newFakeRegistry(t, "bearer")
+	f.tokenTTL = 60
+	f.digests["team/app:1.0.0"] = "sha256:aaa"
+
+	clock := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	d := NewDistribution(f.srv.URL)
+	d.now = func() time.Time { return clock }
+
+	head := func() {
+		t.Helper()
+		if _, _, _, err := d.HeadDigest(context.Background(), "team/app", "1.0.0", ""); err != nil {
+			t.Fatalf("HeadDigest error: %v", err)
+		}
+	}
+
+	head()
+	clock = clock.Add(30 * time.Second)
+	head()
+	if f.tokenCalls != 1 {
+		t.Fatalf("token still valid, expected 1 token call, got %d", f.tokenCalls)
+	}
+
+	clock = clock.Add(25 * time.Second) // 55s: inside the refresh margin
+	head()
+	if f.tokenCalls != 2 {
+		t.Fatalf("expected proactive refresh, got %d token calls", f.tokenCalls)
+	}
+	if f.requests != 3 {
+		t.Fatalf("refresh should not cost a failed request, saw %d", f.requests)
+	}
+}
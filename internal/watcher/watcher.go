@@ -2,20 +2,35 @@ package watcher
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/jpvargasdev/magos-dominus/internal/events"
+	pc "github.com/jpvargasdev/magos-dominus/internal/policy"
 	"github.com/jpvargasdev/magos-dominus/internal/state"
 )
 
 type Target struct {
 	Name     string   // logical name (service or file reference)
+	Line     int      // annotated image line in Name, passed on in events
 	Image    ImageRef // parsed reference
-	Policy   string   // "semver", "latest", "digest", "manual"
-	Interval int      // optional: poll interval in seconds (could default)
+	Policy   string   // a registered policy name, see policy.Names
+	Interval int      // optional: poll interval in seconds; 0 uses WatcherConfig.PollInterval
+	// Platform ("linux/arm64", "host") tracks one image of a multi-arch
+	// index instead of the index digest; "" uses WatcherConfig.Platform.
+	Platform string
+	// PinPlatform writes the per-platform digest for the "digest" policy
+	// instead of the (portable) index digest.
+	PinPlatform bool
+	// MinAge holds back a new image until it was built at least this long
+	// ago, giving broken releases time to be pulled or fixed. 0 disables it.
+	MinAge time.Duration
+	// Options narrow which tags the policy may pick (semver range, ...).
+	Options pc.Options
 }
 
 type ImageRef struct {
@@ -25,11 +40,28 @@ type ImageRef struct {
 	Tag      string
 }
 
+// Repository is the path under /v2/ on the registry: "owner/name", or just
+// "name" for registries without namespaces.
+func (i ImageRef) Repository() string {
+	if i.Owner == "" {
+		return i.Name
+	}
+	return i.Owner + "/" + i.Name
+}
+
 type WatcherConfig struct {
 	Registry     string
 	DefaultTag   string
 	PollInterval time.Duration
+	Workers      int    // concurrent registry checks; 0 uses defaultWorkers
+	Platform     string // default Target.Platform; "" tracks index digests
 	Targets      []Target
+	// CosignKeys, when set, holds back new digests of the images it has a
+	// key for until their signature verifies.
+	CosignKeys *CosignKeys
+	// Registries, when set, is shared with other users of the registries
+	// so they go through the same clients, tokens and rate limits.
+	Registries *Registries
 }
 
 type Config struct {
@@ -37,99 +69,364 @@ type Config struct {
 }
 
 type Watcher struct {
-	targets []Target
-	emitter events.Emitter
+	targets      []Target
+	emitter      events.Emitter
+	pollInterval time.Duration
+	workers      int
+	cosign       *CosignKeys
+	regs         *Registries
 }
 
+const (
+	defaultWorkers = 4
+	// targetTimeout bounds one target check: tag listing plus manifest HEAD,
+	// including any wait on the registry rate limiter.
+	targetTimeout = 2 * time.Minute
+)
+
 func New(targets []Target, em events.Emitter) *Watcher {
-	return &Watcher{targets: targets, emitter: em}
+	return NewFromConfig(WatcherConfig{Targets: targets}, em)
+}
+
+// NewFromConfig uses cfg.PollInterval as the default for targets that do not
+// set their own Interval, and polls with cfg.Workers workers.
+func NewFromConfig(cfg WatcherConfig, em events.Emitter) *Watcher {
+	iv := cfg.PollInterval
+	if iv <= 0 {
+		iv = defaultPollInterval
+	}
+	workers := cfg.Workers
+	if workers <= 0 {
+		workers = defaultWorkers
+	}
+	targets := make([]Target, len(cfg.Targets))
+	for i, t := range cfg.Targets {
+		if t.Platform == "" {
+			t.Platform = cfg.Platform
+		}
+		targets[i] = t
+	}
+	return &Watcher{targets: targets, emitter: em, pollInterval: iv, workers: workers, cosign: cfg.CosignKeys, regs: cfg.Registries}
 }
 
 func (w *Watcher) Start(ctx context.Context, st *state.File) error {
-	ghcr := NewGHCR()
+	regs := w.regs
+	if regs == nil {
+		regs = NewRegistries()
+	}
 
 	if len(w.targets) == 0 {
 		log.Printf("[watcher] no targets configured; idle")
+		<-ctx.Done()
+		log.Printf("[watcher] context canceled, stopping")
+		return ctx.Err()
 	}
 
-	ticker := time.NewTicker(1 * time.Minute)
-	defer ticker.Stop()
+	return w.dispatch(ctx, regs, st, newSchedule(w.targets, w.pollInterval, time.Now()))
+}
+
+// checkResult is a finished target check, by index into Watcher.targets.
+type checkResult struct {
+	i   int
+	err error
+}
+
+// dispatch feeds due targets to a bounded pool of workers as they come due
+// and reschedules each one when its own check finishes, so a slow registry
+// only holds up the workers waiting on it. Targets that share a state key
+// (the same image watched from several files) never run at the same time,
+// so their Get/Upsert sequences do not interleave.
+func (w *Watcher) dispatch(ctx context.Context, regs *Registries, st *state.File, sched *schedule) error {
+	jobs := make(chan int)
+	// one slot per target: a worker never blocks reporting back
+	results := make(chan checkResult, len(w.targets))
+	var wg sync.WaitGroup
+	for n := min(w.workers, len(w.targets)); n > 0; n-- {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				tctx, cancel := context.WithTimeout(ctx, targetTimeout)
+				err := w.check(tctx, regs, st, w.targets[i])
+				cancel()
+				results <- checkResult{i, err}
+			}
+		}()
+	}
+	defer func() {
+		close(jobs)
+		wg.Wait()
+	}()
 
-	w.runOnce(ctx, ghcr, st)
+	keys := make([]string, len(w.targets))
+	for i, t := range w.targets {
+		keys[i] = stateKey(t)
+	}
+	busy := make(map[string]bool)
+	var queue []int // due targets, in order, waiting for a worker
 
+	timer := time.NewTimer(0)
+	defer timer.Stop()
 	for {
+		for _, i := range sched.due(time.Now()) {
+			sched.start(i)
+			queue = append(queue, i)
+		}
+		// the first queued target whose key is free goes to the next idle worker
+		var send chan int
+		next := -1
+		for q, i := range queue {
+			if !busy[keys[i]] {
+				send, next = jobs, q
+				break
+			}
+		}
+		job := -1
+		if next >= 0 {
+			job = queue[next]
+		}
+
 		select {
 		case <-ctx.Done():
 			log.Printf("[watcher] context canceled, stopping")
 			return ctx.Err()
-		case <-ticker.C:
-			w.runOnce(ctx, ghcr, st)
+
+		case send <- job:
+			busy[keys[job]] = true
+			queue = append(queue[:next], queue[next+1:]...)
+
+		case r := <-results:
+			busy[keys[r.i]] = false
+			now := time.Now()
+			if r.err == nil {
+				sched.done(r.i, now)
+			} else {
+				d := sched.fail(r.i, now, r.err)
+				log.Printf("[watcher] %s: %d failure(s) in a row, next check in %s",
+					w.targets[r.i].Image.Repository(), sched.failures[r.i], d.Round(time.Second))
+			}
+			// persist after every check so "magos-dominus explain" sees fresh decisions
+			if err := st.Save(); err != nil {
+				log.Printf("[watcher] state save: %v", err)
+			}
+
+		case <-timer.C:
+		}
+
+		if wake, ok := sched.nextWake(); ok {
+			timer.Reset(time.Until(wake))
 		}
 	}
 }
 
-func (w *Watcher) runOnce(ctx context.Context, ghcr *GHCR, st *state.File) {
-	for _, t := range w.targets {
-		repo := fmt.Sprintf("%s/%s", t.Image.Owner, t.Image.Name)
-		refIn := strings.ToLower(t.Image.Tag)
-
-		// For semver, store under a stable "channel" key
-		refKey := refIn
-		if strings.EqualFold(t.Policy, "semver") {
-			refKey = "semver"
+// stateKey is where a target's last-seen digest lives in state.File.
+// Platform-tracking targets get their own key so an arm64 and an amd64 view
+// of the same tag do not overwrite each other.
+func stateKey(t Target) string {
+	// Tag-picking policies store under a stable "channel" key; options make
+	// their own channel so postgres 15.x and 16.x watchers do not share a
+	// baseline.
+	refKey := strings.ToLower(t.Image.Tag)
+	if p, err := pc.Lookup(t.Policy); err == nil && p.PicksTags() {
+		refKey = p.Name()
+		if o := t.Options.String(); o != "" {
+			refKey += "(" + o + ")"
 		}
-
-		key := state.Key(
-			strings.ToLower(t.Image.Registry),
-			strings.ToLower(t.Image.Owner),
-			strings.ToLower(t.Image.Name),
-			refKey,
-		)
-
-		prev, ok := st.Get(key)
-		etagIn := ""
-		if ok {
-			etagIn = prev.ETag
+		if c, ok := p.(pc.Channeler); ok {
+			refKey += c.Channel(t.Image.Tag, t.Options)
 		}
+	}
+	if t.Platform != "" {
+		refKey += "@" + strings.ToLower(t.Platform)
+	}
+	return state.Key(
+		strings.ToLower(t.Image.Registry),
+		strings.ToLower(t.Image.Owner),
+		strings.ToLower(t.Image.Name),
+		refKey,
+	)
+}
 
-		digest, resolvedRef, etagOut, notMod, err := ghcr.HeadDigest(ctx, repo, refIn, etagIn, t.Policy)
+// check polls one target. Only registry failures are returned; they drive the
+// target's backoff.
+func (w *Watcher) check(ctx context.Context, regs *Registries, st *state.File, t Target) error {
+	repo := t.Image.Repository()
+	refIn := strings.ToLower(t.Image.Tag)
+
+	reg, err := regs.For(t.Image.Registry)
+	if err != nil {
+		log.Printf("[watcher] skip %s/%s:%s: %v", t.Image.Registry, repo, refIn, err)
+		return err
+	}
+
+	key := stateKey(t)
+
+	prev, ok := st.Get(key)
+	etagIn := ""
+	if ok {
+		etagIn = prev.ETag
+	}
+
+	// Tag-picking policies resolve relative to the tag last adopted, which
+	// is newer than the compose file we started from after an update.
+	current := refIn
+	if ok && prev.Ref != "" && pc.IsTagPolicy(t.Policy) {
+		current = prev.Ref
+	}
+
+	// The policy stage runs here rather than inside HeadDigest so its
+	// decision (and the tags removed by skip rules) can be recorded.
+	candidate, pd, err := resolveCandidate(ctx, reg, repo, current, t.Policy, t.Options)
+	dec := state.Decision{Policy: pd}
+	explain := func(outcome, detail string) {
+		dec.At = time.Now().UTC()
+		dec.Outcome, dec.Detail = outcome, detail
+		st.SetDecision(key, dec)
+	}
+	skipped := pd.Skipped()
+	if len(skipped) > 0 {
+		log.Printf("[watcher] %s: skip rules removed %s", repo, strings.Join(skipped, ", "))
+	}
+	defer st.SetSkipped(key, skipped)
+	var downgrade *pc.DowngradeError
+	if errors.As(err, &downgrade) {
+		log.Printf("[watcher] REFUSED downgrade of %s: %s -> %s; set \"allowDowngrade\": true in the annotation to permit",
+			repo, downgrade.Current, downgrade.Candidate)
+		st.UpdateChecked(key, t.Policy)
+		explain(state.OutcomeRefused, downgrade.Error())
+		return nil
+	}
+	if err != nil {
+		log.Printf("[watcher] skip %s:%s: %v", repo, refIn, err)
+		explain(state.OutcomeError, err.Error())
+		return err
+	}
+
+	resolvedRef := candidate
+	digest, etagOut, notMod, err := reg.HeadDigest(ctx, repo, candidate, etagIn)
+	if err != nil {
+		log.Printf("[watcher] skip %s:%s: %v", repo, refIn, err)
+		explain(state.OutcomeError, err.Error())
+		return err
+	}
+	dec.Ref, dec.Digest = resolvedRef, digest
+
+	// Log with resolved ref (fixes the confusion)
+	log.Printf("[watcher] repo=%s resolvedRef=%s policy=%s notMod=%v", repo, resolvedRef, t.Policy, notMod)
+
+	if notMod {
+		st.UpdateChecked(key, t.Policy)
+		dec.Digest = prev.Digest
+		explain(state.OutcomeNotModified, "")
+		return nil
+	}
+
+	// digest is what change detection compares; pin is what gets written
+	// for the "digest" policy. They differ only when tracking a platform.
+	// signed is what a cosign signature covers: the digest the tag points at.
+	pin, signed := digest, digest
+	if t.Platform != "" {
+		p, err := ParsePlatform(t.Platform)
 		if err != nil {
 			log.Printf("[watcher] skip %s:%s: %v", repo, refIn, err)
-			continue
+			explain(state.OutcomeError, err.Error())
+			return nil
 		}
+		platDigest, err := platformDigest(ctx, reg, repo, resolvedRef, p)
+		if err != nil {
+			log.Printf("[watcher] skip %s:%s: %v", repo, refIn, err)
+			explain(state.OutcomeError, err.Error())
+			return err
+		}
+		log.Printf("[watcher] repo=%s platform=%s index=%s image=%s", repo, p, digest, platDigest)
+		digest = platDigest
+		dec.Digest = digest
+		if t.PinPlatform {
+			pin = platDigest
+		}
+	}
 
-		// Log with resolved ref (fixes the confusion)
-		log.Printf("[watcher] repo=%s resolvedRef=%s policy=%s notMod=%v", repo, resolvedRef, t.Policy, notMod)
+	// Seed baseline if none
+	if !ok {
+		st.UpsertDigest(key, digest, etagOut, t.Policy)
+		st.SetRef(key, resolvedRef)
+		explain(state.OutcomeSeeded, "")
+		st.Save()
+		log.Printf("[watcher] seeded baseline for %s:%s -> %s", repo, resolvedRef, digest)
+		return nil
+	}
+
+	if prev.Digest == digest {
+		st.UpsertDigest(key, digest, etagOut, t.Policy)
+		st.SetRef(key, resolvedRef)
+		explain(state.OutcomeUnchanged, "")
+		return nil
+	}
 
-		if notMod {
+	// Too young: leave the state alone so the change is seen again next poll.
+	if t.MinAge > 0 {
+		var p *Platform
+		if t.Platform != "" {
+			pp, _ := ParsePlatform(t.Platform)
+			p = &pp
+		}
+		created, err := imageCreated(ctx, reg, repo, digest, p)
+		if err != nil {
+			log.Printf("[watcher] hold %s:%s: minAge %s set but %v", repo, resolvedRef, t.MinAge, err)
 			st.UpdateChecked(key, t.Policy)
-			continue
+			explain(state.OutcomeHeld, fmt.Sprintf("minAge %s set but %v", t.MinAge, err))
+			return nil
 		}
-
-		// Seed baseline if none
-		if !ok {
-			st.UpsertDigest(key, digest, etagOut, t.Policy)
-			st.Save()
-			log.Printf("[watcher] seeded baseline for %s:%s -> %s", repo, resolvedRef, digest)
-			continue
+		if age := time.Since(created); age < t.MinAge {
+			log.Printf("[watcher] hold %s:%s: built %s ago, minAge %s", repo, resolvedRef, age.Round(time.Minute), t.MinAge)
+			st.UpdateChecked(key, t.Policy)
+			explain(state.OutcomeHeld, fmt.Sprintf("built %s ago, minAge %s", age.Round(time.Minute), t.MinAge))
+			return nil
 		}
+	}
 
-		if prev.Digest == digest {
-			st.UpsertDigest(key, digest, etagOut, t.Policy)
-			continue
+	// Unsigned: like minAge, leave the state alone so a signature pushed
+	// after the image is picked up on a later poll.
+	var verified *state.Verification
+	if ck := w.cosign.For(t.Image.Registry + "/" + repo); ck != nil {
+		v := state.Verification{Digest: signed, Key: ck.Path, At: time.Now().UTC()}
+		err := verifyCosign(ctx, reg, repo, signed, ck)
+		var regErr *RegistryError
+		switch {
+		case errors.As(err, &regErr):
+			log.Printf("[watcher] skip %s:%s: cosign: %v", repo, resolvedRef, err)
+			explain(state.OutcomeError, "cosign: "+err.Error())
+			return err
+		case err != nil:
+			log.Printf("[watcher] REFUSED %s:%s (%s): cosign: %v", repo, resolvedRef, signed, err)
+			v.Detail = err.Error()
+			st.UpdateChecked(key, t.Policy)
+			st.SetVerification(key, v)
+			explain(state.OutcomeRefused, "cosign: "+err.Error())
+			return nil
 		}
+		v.Verified = true
+		verified = &v
+		log.Printf("[watcher] %s:%s: cosign signature verified with %s", repo, resolvedRef, ck.Path)
+	}
 
-		changed := st.UpsertDigest(key, digest, etagOut, t.Policy)
-		if changed {
-			log.Printf("[watcher] update: %s:%s -> digest=%s", repo, resolvedRef, digest)
-			w.emitter.Emit(events.Event{
-				Discovered: time.Now().UTC(),
-				File:       t.Name,
-				Repo:       repo,
-				Ref:        resolvedRef, // <- the semver-resolved ref
-				Digest:     digest,
-				Policy:     t.Policy,
-			})
-		}
+	changed := st.UpsertDigest(key, digest, etagOut, t.Policy)
+	st.SetRef(key, resolvedRef)
+	if verified != nil {
+		st.SetVerification(key, *verified)
+	}
+	explain(state.OutcomeUpdated, "")
+	if changed {
+		log.Printf("[watcher] update: %s:%s -> digest=%s", repo, resolvedRef, digest)
+		w.emitter.Emit(events.Event{
+			Discovered: time.Now().UTC(),
+			File:       t.Name,
+			Line:       t.Line,
+			Repo:       repo,
+			Ref:        resolvedRef, // <- the semver-resolved ref
+			Digest:     pin,
+			Policy:     t.Policy,
+		})
 	}
+	return nil
 }
@@ -0,0 +1,208 @@
+package watcher
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jpvargasdev/magos-dominus/internal/events"
+	pc "github.com/jpvargasdev/magos-dominus/internal/policy"
+	"github.com/jpvargasdev/magos-dominus/internal/state"
+)
+
+// runDispatch runs w's dispatcher until stop returns true or ten seconds
+// have passed, and returns once it has stopped.
+func runDispatch(t *testing.T, w *Watcher, regs *Registries, st *state.File, sched *schedule, stop func() bool) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		w.dispatch(ctx, regs, st, sched)
+	}()
+	deadline := time.Now().Add(10 * time.Second)
+	for !stop() && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+	<-done
+}
+
+func TestDispatch_ConcurrentTargetsEmitChanges(t *testing.T) {
+	f := newFakeRegistry(t, "bearer")
+	host := mustHost(t, f.srv.URL)
+	t.Setenv("MD_REGISTRY_RATE_LIMITS", host+"=1000:100")
+
+	st := state.New(filepath.Join(t.TempDir(), "state.json"))
+	var targets []Target
+	for i := 0; i < 12; i++ {
+		name := fmt.Sprintf("app%d", i)
+		f.digests["team/"+name+":1.0.0"] = "sha256:new-" + name
+		tg := Target{
+			Name:   "/git/" + name + "/compose.yml",
+			Image:  ImageRef{Registry: host, Owner: "team", Name: name, Tag: "1.0.0"},
+			Policy: "latest",
+		}
+		// seed a previous digest so the check reports a change
+		st.UpsertDigest(stateKey(tg), "sha256:old", "", tg.Policy)
+		targets = append(targets, tg)
+	}
+	// the same image watched from a second file shares the state key
+	dup := targets[0]
+	dup.Name = "/git/other/compose.yml"
+	targets = append(targets, dup)
+
+	em := make(events.ChanEmitter, 64)
+	w := NewFromConfig(WatcherConfig{Workers: 4, Targets: targets}, em)
+	sched := newSchedule(w.targets, w.pollInterval, time.Now())
+	runDispatch(t, w, NewRegistriesWithKeychain(nil), st, sched, func() bool {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		// twelve changes, and the duplicate target has asked the registry too
+		return len(em) >= 12 && f.requests >= len(targets)
+	})
+	close(em)
+
+	seen := map[string]bool{}
+	for ev := range em {
+		if seen[ev.Repo] {
+			t.Fatalf("duplicate event for %s", ev.Repo)
+		}
+		seen[ev.Repo] = true
+		if ev.Digest != "sha256:new-"+ev.Repo[len("team/"):] {
+			t.Fatalf("event %s has digest %s", ev.Repo, ev.Digest)
+		}
+	}
+	if len(seen) != 12 {
+		t.Fatalf("expected 12 events, got %d", len(seen))
+	}
+	if f.tokenCalls > 12 {
+		t.Fatalf("tokens should be cached per repo, got %d token calls", f.tokenCalls)
+	}
+}
+
+// stallRegistry hangs on HeadDigest for "team/stuck" until the check times
+// out and answers every other repository at once, counting the calls.
+type stallRegistry struct {
+	Registry
+	mu    sync.Mutex
+	calls map[string]int
+}
+
+func (r *stallRegistry) HeadDigest(ctx context.Context, repo, ref, etag string) (string, string, bool, error) {
+	r.mu.Lock()
+	r.calls[repo]++
+	r.mu.Unlock()
+	if repo == "team/stuck" {
+		<-ctx.Done()
+		return "", "", false, ctx.Err()
+	}
+	return "sha256:" + ref, "", false, nil
+}
+
+func (r *stallRegistry) count(repo string) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.calls[repo]
+}
+
+func TestDispatch_StuckTargetDoesNotHoldOthers(t *testing.T) {
+	reg := &stallRegistry{calls: make(map[string]int)}
+	regs := NewRegistriesWithKeychain(nil)
+	regs.clients["registry.test"] = reg
+
+	targets := []Target{
+		{Name: "stuck", Image: ImageRef{Registry: "registry.test", Owner: "team", Name: "stuck", Tag: "1"}, Policy: "latest"},
+		{Name: "fast", Image: ImageRef{Registry: "registry.test", Owner: "team", Name: "fast", Tag: "1"}, Policy: "latest"},
+	}
+	w := NewFromConfig(WatcherConfig{Workers: 2, Targets: targets}, make(events.ChanEmitter, 16))
+	sched := newSchedule(w.targets, w.pollInterval, time.Now())
+	sched.intervals[1] = 20 * time.Millisecond
+
+	st := state.New(filepath.Join(t.TempDir(), "state.json"))
+	runDispatch(t, w, regs, st, sched, func() bool { return reg.count("team/fast") >= 5 })
+
+	if n := reg.count("team/fast"); n < 5 {
+		t.Fatalf("fast target polled %d times while the other hung", n)
+	}
+	if n := reg.count("team/stuck"); n != 1 {
+		t.Fatalf("stuck target checked %d times, want 1", n)
+	}
+}
+
+func TestStateKey_SeparatesSemverChannels(t *testing.T) {
+	base := Target{Image: ImageRef{Registry: "docker.io", Owner: "library", Name: "postgres", Tag: "16.4"}, Policy: "semver"}
+	pg16, rc := base, base
+	pg16.Options = pc.Options{Range: "~16"}
+	rc.Options = pc.Options{Prerelease: "rc"}
+
+	keys := map[string]bool{stateKey(base): true, stateKey(pg16): true, stateKey(rc): true}
+	if len(keys) != 3 {
+		t.Fatalf("differently configured semver targets share a key: %v", keys)
+	}
+	other := base
+	other.Image.Tag = "15.8"
+	if stateKey(other) != stateKey(base) {
+		t.Fatalf("semver key must not depend on the current tag")
+	}
+
+	// with an update level, the key follows the release line, not the tag
+	minor15, minor16, minor16b := base, base, base
+	minor15.Image.Tag, minor16.Image.Tag, minor16b.Image.Tag = "15.8.0", "16.4.0", "16.9.1"
+	for _, tg := range []*Target{&minor15, &minor16, &minor16b} {
+		tg.Options = pc.Options{Update: pc.UpdateMinor}
+	}
+	if stateKey(minor15) == stateKey(minor16) || stateKey(minor16) != stateKey(minor16b) {
+		t.Fatalf("update keys: %s %s %s", stateKey(minor15), stateKey(minor16), stateKey(minor16b))
+	}
+
+	// variants of one image are separate channels
+	alpine := base
+	alpine.Image.Tag = "16.4.0-alpine"
+	if stateKey(alpine) == stateKey(base) {
+		t.Fatalf("alpine and plain semver targets share key %s", stateKey(base))
+	}
+}
+
+func TestCheck_RecordsDecision(t *testing.T) {
+	f := newFakeRegistry(t, "bearer")
+	host := mustHost(t, f.srv.URL)
+	f.tags["team/api"] = []string{"1.0.0", "1.1.0", "2.0.0", "latest"}
+	f.digests["team/api:1.1.0"] = "sha256:one-one"
+
+	st := state.New(filepath.Join(t.TempDir(), "state.json"))
+	tg := Target{
+		Name:    "/git/api/compose.yml",
+		Image:   ImageRef{Registry: host, Owner: "team", Name: "api", Tag: "1.0.0"},
+		Policy:  "semver",
+		Options: pc.Options{Range: "~1"},
+	}
+	w := New([]Target{tg}, make(events.ChanEmitter, 1))
+	regs := NewRegistriesWithKeychain(nil)
+	// the second check reuses the ETag, so the registry answers 304
+	for _, want := range []string{state.OutcomeSeeded, state.OutcomeNotModified} {
+		if err := w.check(context.Background(), regs, st, tg); err != nil {
+			t.Fatalf("check: %v", err)
+		}
+		c, _ := st.LastCheck(stateKey(tg))
+		if c.Decision == nil || c.Decision.Outcome != want {
+			t.Fatalf("want outcome %s, got %+v", want, c.Decision)
+		}
+	}
+
+	c, _ := st.LastCheck(stateKey(tg))
+	d := c.Decision
+	if d.Ref != "1.1.0" || d.Digest != "sha256:one-one" || d.Policy.Chosen != "1.1.0" {
+		t.Fatalf("unexpected decision: %+v %+v", d, d.Policy)
+	}
+	reasons := map[string]string{}
+	for _, r := range d.Policy.Rejected {
+		reasons[r.Tag] = r.Reason
+	}
+	if reasons["2.0.0"] != `outside range "~1"` || reasons["latest"] != "not semver" {
+		t.Fatalf("unexpected rejections: %v", reasons)
+	}
+}